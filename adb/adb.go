@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"fmt"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/bitrise-io/go-utils/v2/command"
@@ -27,119 +26,277 @@ func New(androidHome string, cmdFactory command.Factory, logger log.Logger) ADB
 
 const DeviceStateConnected = "device"
 
+// Device is an entry of the `adb devices -l` output.
+type Device struct {
+	Serial      string
+	State       string
+	USB         string
+	Product     string
+	Model       string
+	Device      string
+	TransportID string
+}
+
+// IsEmulator reports whether the device is a locally started emulator, based on its serial.
+func (d Device) IsEmulator() bool {
+	return strings.HasPrefix(d.Serial, "emulator-")
+}
+
 // Key: device serial number
 // Value: device state
 type Devices map[string]string
 
-// Devices returns a map of connected Android devices and their states.
-func (a *ADB) Devices() (Devices, error) {
+// ListDevices returns the connected Android devices: emulators, TCP-connected and physical devices,
+// in any state (e.g. device, offline, unauthorized, recovery, sideload).
+func (a *ADB) ListDevices() ([]Device, error) {
 	cmd := a.cmdFactory.Create(
 		filepath.Join(a.androidHome, "platform-tools", "adb"),
-		[]string{"devices"},
+		[]string{"devices", "-l"},
 		nil,
 	)
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
 	if err != nil {
 		a.logger.Printf(out)
-		return map[string]string{}, fmt.Errorf("adb devices: %s", err)
+		return nil, fmt.Errorf("adb devices: %s", err)
 	}
 
 	a.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
 	a.logger.Debugf("%s", out)
 
-	// List of devices attached
-	// emulator-5554	device
-	deviceListItemPattern := `^(?P<emulator>emulator-\d*)[\s+](?P<state>.*)`
-	deviceListItemRegexp := regexp.MustCompile(deviceListItemPattern)
+	devices, err := parseDevices(out)
+	if err != nil {
+		return nil, fmt.Errorf("scan adb devices output: %s", err)
+	}
+	return devices, nil
+}
+
+func isDeviceAttribute(key string) bool {
+	switch key {
+	case "usb", "product", "model", "device", "transport_id":
+		return true
+	}
+	return false
+}
+
+// Devices returns a map of connected Android devices and their states.
+func (a *ADB) Devices() (Devices, error) {
+	devices, err := a.ListDevices()
+	if err != nil {
+		return map[string]string{}, err
+	}
 
 	deviceStateMap := map[string]string{}
+	for _, device := range devices {
+		deviceStateMap[device.Serial] = device.State
+	}
+	return deviceStateMap, nil
+}
+
+// parseDevices parses the `adb devices` and `adb devices -l` output:
+//
+// List of devices attached
+// emulator-5554          device product:sdk_gphone64_x86_64 model:sdk_gphone64_x86_64 device:emu64x transport_id:1
+// localhost:5555         offline transport_id:2
+// 0123456789ABCDEF       unauthorized usb:1-1 transport_id:3
+func parseDevices(out string) ([]Device, error) {
+	var devices []Device
 
 	scanner := bufio.NewScanner(strings.NewReader(out))
 	for scanner.Scan() {
-		line := scanner.Text()
-		matches := deviceListItemRegexp.FindStringSubmatch(line)
-		if len(matches) == 3 {
-			serial := matches[1]
-			state := matches[2]
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "List of devices attached") || strings.HasPrefix(line, "* daemon") {
+			continue
+		}
 
-			deviceStateMap[serial] = state
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
 		}
 
+		device := Device{Serial: fields[0]}
+		// The state might consist of multiple words, e.g. "no permissions (...)", so it lasts until the first attribute.
+		var stateWords []string
+		attributes := false
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, ":")
+			attributes = attributes || (len(stateWords) > 0 && isDeviceAttribute(key))
+			if !attributes {
+				stateWords = append(stateWords, field)
+				continue
+			}
+
+			switch key {
+			case "usb":
+				device.USB = value
+			case "product":
+				device.Product = value
+			case "model":
+				device.Model = value
+			case "device":
+				device.Device = value
+			case "transport_id":
+				device.TransportID = value
+			}
+		}
+		device.State = strings.Join(stateWords, " ")
+
+		devices = append(devices, device)
 	}
-	if scanner.Err() != nil {
-		return map[string]string{}, fmt.Errorf("scan adb devices output: %s", err)
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 
-	return deviceStateMap, nil
+	return devices, nil
 }
 
 // FindNewDevice returns the serial number of a newly connected device compared
 // to the previous state of running devices.
 // If no new device is found, an empty string is returned.
 func (a *ADB) FindNewDevice(previousDeviceState Devices) (string, error) {
-	devicesNow, err := a.Devices()
+	devicesNow, err := a.ListDevices()
 	if err != nil {
 		return "", err
 	}
 
-	newDeviceSerial := ""
-	for serial := range devicesNow {
-		_, found := previousDeviceState[serial]
-		if !found {
-			newDeviceSerial = serial
-			break
+	for _, device := range devicesNow {
+		// Only locally started emulators are candidates, a physical device plugged in meanwhile is not the one we booted.
+		if !device.IsEmulator() {
+			continue
 		}
-	}
-
-	if len(newDeviceSerial) > 0 {
-		state := devicesNow[newDeviceSerial]
-		if state == DeviceStateConnected {
-			return newDeviceSerial, nil
+		if _, found := previousDeviceState[device.Serial]; found {
+			continue
 		}
+		if device.State == DeviceStateConnected {
+			return device.Serial, nil
+		}
+		return "", nil
 	}
 
 	return "", nil
 }
 
-func (a *ADB) DisableAnimations(serial string) error {
-	a.logger.Println()
-	a.logger.Infof("Disabling animations for %s", serial)
+// Shell runs a command in the device shell and returns its output.
+func (a *ADB) Shell(serial string, args ...string) (string, error) {
+	cmd := a.cmdFactory.Create(
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		append([]string{"-s", serial, "shell"}, args...),
+		nil,
+	)
+	a.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
+
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("adb shell %s: %s, output: %s", strings.Join(args, " "), err, out)
+	}
+	return out, nil
+}
+
+// KillServer kills the adb server. The next adb command starts a new one.
+func (a *ADB) KillServer() error {
+	cmd := a.cmdFactory.Create(
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		[]string{"kill-server"},
+		nil,
+	)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return fmt.Errorf("adb kill-server: %s, output: %s", err, out)
+	}
+	return nil
+}
+
+// Connect connects to a device over TCP/IP, address is in host:port format.
+func (a *ADB) Connect(address string) error {
+	cmd := a.cmdFactory.Create(
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		[]string{"connect", address},
+		nil,
+	)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return fmt.Errorf("adb connect %s: %s, output: %s", address, err, out)
+	}
+
+	a.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
+	a.logger.Debugf("%s", out)
 
-	adbPath := filepath.Join(a.androidHome, "platform-tools", "adb")
+	// adb connect exits with 0 even if the connection fails, the outcome is only in the output:
+	// connected to localhost:5555
+	// already connected to localhost:5555
+	// failed to connect to 'localhost:5555': Connection refused
+	if !strings.HasPrefix(out, "connected to") && !strings.HasPrefix(out, "already connected to") {
+		return fmt.Errorf("adb connect %s: %s", address, out)
+	}
+	return nil
+}
 
+// Root restarts adbd with root permissions. Only debuggable (userdebug, eng) builds allow it,
+// Play Store images don't.
+func (a *ADB) Root(serial string) error {
 	cmd := a.cmdFactory.Create(
-		adbPath,
-		[]string{"-s", serial, "shell", "settings", "put", "global", "window_animation_scale", "0"},
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		[]string{"-s", serial, "root"},
 		nil,
 	)
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
 	if err != nil {
-		a.logger.Warnf(out)
-		a.logger.Warnf("adb window_animation_scal=0: %s", err)
+		return fmt.Errorf("adb root: %s, output: %s", err, out)
 	}
 
-	cmd = a.cmdFactory.Create(
-		adbPath,
-		[]string{"-s", serial, "shell", "settings", "put", "global", "transition_animation_scale", "0"},
+	// adbd cannot run as root in production builds
+	if strings.Contains(out, "cannot run as root") {
+		return fmt.Errorf("adb root: %s", out)
+	}
+	return nil
+}
+
+// WaitForOnline blocks until the device is online, e.g. after adbd restarted.
+func (a *ADB) WaitForOnline(serial string) error {
+	cmd := a.cmdFactory.Create(
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		[]string{"-s", serial, "wait-for-device"},
 		nil,
 	)
-	out, err = cmd.RunAndReturnTrimmedCombinedOutput()
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
 	if err != nil {
-		a.logger.Warnf(out)
-		a.logger.Warnf("adb transition_animation_scale=0: %s", err)
+		return fmt.Errorf("adb wait-for-device: %s, output: %s", err, out)
 	}
+	return nil
+}
 
-	cmd = a.cmdFactory.Create(
-		adbPath,
-		[]string{"-s", serial, "shell", "settings", "put", "global", "animator_duration_scale", "0"},
+// Remount remounts the system partition writable, which requires root and an emulator started with -writable-system.
+// On images with dm-verity, the first remount disables verity and the device has to be rebooted (and remounted)
+// for it to take effect, this is reported with rebootRequired.
+func (a *ADB) Remount(serial string) (rebootRequired bool, err error) {
+	cmd := a.cmdFactory.Create(
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		[]string{"-s", serial, "remount"},
 		nil,
 	)
-	out, err = cmd.RunAndReturnTrimmedCombinedOutput()
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
 	if err != nil {
-		a.logger.Warnf(out)
-		a.logger.Warnf("adb animator_duration_scale=0: %s", err)
+		return false, fmt.Errorf("adb remount: %s, output: %s", err, out)
 	}
 
-	a.logger.Println()
+	switch {
+	case strings.Contains(out, "remount succeeded"):
+		return false, nil
+	case strings.Contains(out, "reboot"):
+		return true, nil
+	default:
+		return false, fmt.Errorf("adb remount: %s", out)
+	}
+}
+
+func (a *ADB) Reboot(serial string) error {
+	cmd := a.cmdFactory.Create(
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		[]string{"-s", serial, "reboot"},
+		nil,
+	)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return fmt.Errorf("adb reboot: %s, output: %s", err, out)
+	}
 	return nil
 }
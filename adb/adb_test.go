@@ -43,12 +43,20 @@ func TestFindNewDevice(t *testing.T) {
 			adbOutput:      "List of devices attached\nemulator-5554\tdevice\nemulator-5556\toffline\n",
 			expectedSerial: "",
 		},
+		{
+			name: "new non-emulator device ignored",
+			previousDevices: Devices{
+				"emulator-5554": "device",
+			},
+			adbOutput:      "List of devices attached\nemulator-5554\tdevice\n0123456789ABCDEF\tdevice usb:1-1 transport_id:3\n",
+			expectedSerial: "",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cmdFactory := test.FakeCommandFactory{
-				Stdout:  tt.adbOutput,
+				Stdout:   tt.adbOutput,
 				ExitCode: 0,
 			}
 			adb := New(androidHome, cmdFactory, logger)
@@ -59,3 +67,151 @@ func TestFindNewDevice(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDevices(t *testing.T) {
+	tests := []struct {
+		name      string
+		adbOutput string
+		want      []Device
+	}{
+		{
+			name:      "no devices",
+			adbOutput: "List of devices attached\n",
+			want:      nil,
+		},
+		{
+			name:      "daemon start messages",
+			adbOutput: "* daemon not running; starting now at tcp:5037\n* daemon started successfully\nList of devices attached\nemulator-5554\tdevice\n",
+			want: []Device{
+				{Serial: "emulator-5554", State: "device"},
+			},
+		},
+		{
+			name: "long format",
+			adbOutput: `List of devices attached
+emulator-5554          device product:sdk_gphone64_x86_64 model:sdk_gphone64_x86_64 device:emu64x transport_id:1
+localhost:5555         device product:sdk_gphone64_arm64 model:sdk_gphone64_arm64 device:emu64a transport_id:2
+0123456789ABCDEF       device usb:1-1 product:oriole model:Pixel_6 device:oriole transport_id:3
+`,
+			want: []Device{
+				{Serial: "emulator-5554", State: "device", Product: "sdk_gphone64_x86_64", Model: "sdk_gphone64_x86_64", Device: "emu64x", TransportID: "1"},
+				{Serial: "localhost:5555", State: "device", Product: "sdk_gphone64_arm64", Model: "sdk_gphone64_arm64", Device: "emu64a", TransportID: "2"},
+				{Serial: "0123456789ABCDEF", State: "device", USB: "1-1", Product: "oriole", Model: "Pixel_6", Device: "oriole", TransportID: "3"},
+			},
+		},
+		{
+			name: "non-ready states",
+			adbOutput: `List of devices attached
+emulator-5556          offline transport_id:4
+192.168.1.20:5555      unauthorized transport_id:5
+0123456789ABCDEF       recovery usb:1-1 product:oriole model:Pixel_6 device:oriole transport_id:6
+FEDCBA9876543210       sideload usb:1-2 transport_id:7
+`,
+			want: []Device{
+				{Serial: "emulator-5556", State: "offline", TransportID: "4"},
+				{Serial: "192.168.1.20:5555", State: "unauthorized", TransportID: "5"},
+				{Serial: "0123456789ABCDEF", State: "recovery", USB: "1-1", Product: "oriole", Model: "Pixel_6", Device: "oriole", TransportID: "6"},
+				{Serial: "FEDCBA9876543210", State: "sideload", USB: "1-2", TransportID: "7"},
+			},
+		},
+		{
+			name:      "multi-word state",
+			adbOutput: "List of devices attached\n0123456789ABCDEF       no permissions (missing udev rules? user is in the plugdev group); see [http://developer.android.com/tools/device.html] usb:1-1 transport_id:8\n",
+			want: []Device{
+				{Serial: "0123456789ABCDEF", State: "no permissions (missing udev rules? user is in the plugdev group); see [http://developer.android.com/tools/device.html]", USB: "1-1", TransportID: "8"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices, err := parseDevices(tt.adbOutput)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, devices)
+		})
+	}
+}
+
+func TestDevices(t *testing.T) {
+	cmdFactory := test.FakeCommandFactory{
+		Stdout: "List of devices attached\nemulator-5554          device product:sdk_gphone64_x86_64 transport_id:1\nlocalhost:5555         offline transport_id:2\n",
+	}
+	adb := New("/fake/android/home", cmdFactory, log.NewLogger())
+
+	devices, err := adb.Devices()
+	require.NoError(t, err)
+	assert.Equal(t, Devices{"emulator-5554": "device", "localhost:5555": "offline"}, devices)
+}
+
+func TestConnect(t *testing.T) {
+	tests := []struct {
+		name        string
+		adbOutput   string
+		expectError bool
+	}{
+		{
+			name:      "connected",
+			adbOutput: "connected to 10.0.0.5:5555",
+		},
+		{
+			name:      "already connected",
+			adbOutput: "already connected to 10.0.0.5:5555",
+		},
+		{
+			name:        "connection refused",
+			adbOutput:   "failed to connect to '10.0.0.5:5555': Connection refused",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adb := New("/fake/android/home", test.FakeCommandFactory{Stdout: tt.adbOutput}, log.NewLogger())
+
+			err := adb.Connect("10.0.0.5:5555")
+			if tt.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRemount(t *testing.T) {
+	tests := []struct {
+		name               string
+		adbOutput          string
+		wantRebootRequired bool
+		expectError        bool
+	}{
+		{
+			name:      "remounted",
+			adbOutput: "remount succeeded",
+		},
+		{
+			name:               "verity disabled",
+			adbOutput:          "Disabling verity for /system\nUsing overlayfs for /system\nNow reboot your device for settings to take effect",
+			wantRebootRequired: true,
+		},
+		{
+			name:        "not root",
+			adbOutput:   "Not running as root. Try \"adb root\" first.",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adb := New("/fake/android/home", test.FakeCommandFactory{Stdout: tt.adbOutput}, log.NewLogger())
+
+			rebootRequired, err := adb.Remount("emulator-5554")
+			if tt.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantRebootRequired, rebootRequired)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// retryableInstallCodes are the install failures caused by the package manager state rather than by the APK.
+var retryableInstallCodes = map[string]bool{
+	"INSTALL_FAILED_INTERNAL_ERROR":    true,
+	"INSTALL_FAILED_CONTAINER_ERROR":   true,
+	"INSTALL_FAILED_MEDIA_UNAVAILABLE": true,
+	"INSTALL_FAILED_ABORTED":           true,
+	"INSTALL_FAILED_SESSION_INVALID":   true,
+}
+
+// Failure [INSTALL_FAILED_TEST_ONLY: installPackageLI]
+var installFailurePattern = regexp.MustCompile(`Failure \[((?:INSTALL_[A-Z_]+)|(?:DELETE_[A-Z_]+))(?::\s*([^\]]*))?\]`)
+
+// InstallError is a package manager install failure, e.g. INSTALL_FAILED_VERSION_DOWNGRADE.
+type InstallError struct {
+	Code    string
+	Message string
+}
+
+func (e InstallError) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Retryable reports whether installing again might succeed.
+func (e InstallError) Retryable() bool {
+	return retryableInstallCodes[e.Code]
+}
+
+type InstallOptions struct {
+	// GrantPermissions grants all runtime permissions (-g).
+	GrantPermissions bool
+	// AllowTestOnly allows installing test-only APKs (-t).
+	AllowTestOnly bool
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	// After waits for the retry delay, time.After if nil.
+	After func(d time.Duration) <-chan time.Time
+}
+
+// InstallResult is the outcome of installing an app.
+type InstallResult struct {
+	APKs     []string
+	Attempts int
+	Err      error
+}
+
+// Install installs an app. Multiple APKs are installed together as the splits of a single app (install-multiple).
+// Retryable install failures are retried, at most opts.MaxAttempts times in total, until ctx is done.
+func (a *ADB) Install(ctx context.Context, serial string, apks []string, opts InstallOptions) InstallResult {
+	result := InstallResult{APKs: apks}
+	after := opts.After
+	if after == nil {
+		after = time.After
+	}
+
+	for result.Attempts < max(opts.MaxAttempts, 1) {
+		if result.Attempts > 0 {
+			a.logger.Warnf("Retrying install in %s: %s", opts.RetryDelay, result.Err)
+			select {
+			case <-ctx.Done():
+				result.Err = ctx.Err()
+				return result
+			case <-after(opts.RetryDelay):
+			}
+		}
+		result.Attempts++
+
+		result.Err = a.install(serial, apks, opts)
+		if result.Err == nil {
+			return result
+		}
+		if installErr, ok := result.Err.(InstallError); !ok || !installErr.Retryable() {
+			return result
+		}
+	}
+	return result
+}
+
+func (a *ADB) install(serial string, apks []string, opts InstallOptions) error {
+	args := []string{"-s", serial, "install"}
+	if len(apks) > 1 {
+		args = []string{"-s", serial, "install-multiple"}
+	}
+	// -r: reinstall, the app might be preinstalled on reused devices
+	args = append(args, "-r")
+	if opts.GrantPermissions {
+		args = append(args, "-g")
+	}
+	if opts.AllowTestOnly {
+		args = append(args, "-t")
+	}
+	args = append(args, apks...)
+
+	cmd := a.cmdFactory.Create(filepath.Join(a.androidHome, "platform-tools", "adb"), args, nil)
+	a.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if installErr, ok := parseInstallFailure(out); ok {
+		return installErr
+	}
+	if err != nil {
+		return fmt.Errorf("adb install: %s, output: %s", err, out)
+	}
+	if !strings.Contains(out, "Success") {
+		return fmt.Errorf("adb install: unexpected output: %s", out)
+	}
+	return nil
+}
+
+func parseInstallFailure(out string) (InstallError, bool) {
+	matches := installFailurePattern.FindStringSubmatch(out)
+	if matches == nil {
+		return InstallError{}, false
+	}
+	return InstallError{Code: matches[1], Message: strings.TrimSpace(matches[2])}, true
+}
@@ -0,0 +1,124 @@
+package adb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInstallFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   InstallError
+		found  bool
+	}{
+		{
+			name:   "success",
+			output: "Performing Streamed Install\nSuccess",
+		},
+		{
+			name:   "code only",
+			output: "Performing Streamed Install\nadb: failed to install app.apk: Failure [INSTALL_FAILED_INSUFFICIENT_STORAGE]",
+			want:   InstallError{Code: "INSTALL_FAILED_INSUFFICIENT_STORAGE"},
+			found:  true,
+		},
+		{
+			name:   "code with message",
+			output: "adb: failed to install app.apk: Failure [INSTALL_FAILED_TEST_ONLY: installPackageLI]",
+			want:   InstallError{Code: "INSTALL_FAILED_TEST_ONLY", Message: "installPackageLI"},
+			found:  true,
+		},
+		{
+			name:   "parse failure",
+			output: "Failure [INSTALL_PARSE_FAILED_NO_CERTIFICATES: Failed to collect certificates from /data/app/vmdl.tmp/base.apk]",
+			want:   InstallError{Code: "INSTALL_PARSE_FAILED_NO_CERTIFICATES", Message: "Failed to collect certificates from /data/app/vmdl.tmp/base.apk"},
+			found:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := parseInstallFailure(tt.output)
+			assert.Equal(t, tt.found, found)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestInstall(t *testing.T) {
+	tests := []struct {
+		name         string
+		adbOutput    string
+		exitCode     int
+		wantAttempts int
+		wantCode     string
+	}{
+		{
+			name:         "success",
+			adbOutput:    "Performing Streamed Install\nSuccess",
+			wantAttempts: 1,
+		},
+		{
+			name:         "non-retryable failure",
+			adbOutput:    "adb: failed to install app.apk: Failure [INSTALL_FAILED_VERSION_DOWNGRADE]",
+			wantAttempts: 1,
+			wantCode:     "INSTALL_FAILED_VERSION_DOWNGRADE",
+		},
+		{
+			name:         "retryable failure",
+			adbOutput:    "adb: failed to install app.apk: Failure [INSTALL_FAILED_INTERNAL_ERROR: Session relinquished]",
+			wantAttempts: 3,
+			wantCode:     "INSTALL_FAILED_INTERNAL_ERROR",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adb := New("/fake/android/home", test.FakeCommandFactory{Stdout: tt.adbOutput, ExitCode: tt.exitCode}, log.NewLogger())
+			var delays []time.Duration
+			after := func(d time.Duration) <-chan time.Time {
+				delays = append(delays, d)
+				ch := make(chan time.Time, 1)
+				ch <- time.Time{}
+				return ch
+			}
+
+			result := adb.Install(context.Background(), "emulator-5554", []string{"base.apk", "split_config.xxhdpi.apk"}, InstallOptions{
+				GrantPermissions: true,
+				MaxAttempts:      3,
+				RetryDelay:       time.Minute,
+				After:            after,
+			})
+			require.Equal(t, tt.wantAttempts, result.Attempts)
+			require.Len(t, delays, tt.wantAttempts-1)
+			for _, delay := range delays {
+				require.Equal(t, time.Minute, delay)
+			}
+			if tt.wantCode == "" {
+				require.NoError(t, result.Err)
+				return
+			}
+
+			var installErr InstallError
+			require.ErrorAs(t, result.Err, &installErr)
+			require.Equal(t, tt.wantCode, installErr.Code)
+		})
+	}
+}
+
+func TestInstall_Canceled(t *testing.T) {
+	adb := New("/fake/android/home", test.FakeCommandFactory{Stdout: "Failure [INSTALL_FAILED_INTERNAL_ERROR]"}, log.NewLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result := adb.Install(ctx, "emulator-5554", []string{"app.apk"}, InstallOptions{MaxAttempts: 3, RetryDelay: time.Hour})
+
+	require.Equal(t, 1, result.Attempts)
+	require.ErrorIs(t, result.Err, context.Canceled)
+}
@@ -0,0 +1,176 @@
+package adb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// PortRuleDirection is the adb command setting up the rule.
+type PortRuleDirection string
+
+const (
+	// Forward forwards connections from the host to the device (adb forward).
+	Forward PortRuleDirection = "forward"
+	// Reverse forwards connections from the device to the host (adb reverse).
+	Reverse PortRuleDirection = "reverse"
+)
+
+// PortRule is an adb forward or reverse rule. Listen is the socket accepting connections (on the host for forward,
+// on the device for reverse) and Connect is the socket the connections are forwarded to.
+type PortRule struct {
+	Direction PortRuleDirection
+	Listen    string
+	Connect   string
+}
+
+// String returns the rule in the format accepted by ParsePortRule, e.g. reverse tcp:8080 tcp:8080.
+func (r PortRule) String() string {
+	return fmt.Sprintf("%s %s %s", r.Direction, r.Listen, r.Connect)
+}
+
+// ParsePortRule parses a rule in the <forward|reverse> <listen> <connect> format,
+// e.g. reverse tcp:8080 tcp:8080 or forward tcp:9222 localabstract:chrome_devtools_remote.
+func ParsePortRule(rule string) (PortRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) != 3 {
+		return PortRule{}, fmt.Errorf("invalid rule %q, expected <forward|reverse> <listen> <connect>", rule)
+	}
+
+	r := PortRule{Direction: PortRuleDirection(fields[0]), Listen: fields[1], Connect: fields[2]}
+	if r.Direction != Forward && r.Direction != Reverse {
+		return PortRule{}, fmt.Errorf("invalid rule %q, direction must be forward or reverse", rule)
+	}
+
+	// Debugged processes and character devices exist on the device only, forward rules can connect to them
+	var deviceOnly []string
+	if r.Direction == Forward {
+		deviceOnly = []string{"jdwp", "dev"}
+	}
+	if err := validateSocket(r.Listen, nil); err != nil {
+		return PortRule{}, fmt.Errorf("invalid rule %q: %w", rule, err)
+	}
+	if err := validateSocket(r.Connect, deviceOnly); err != nil {
+		return PortRule{}, fmt.Errorf("invalid rule %q: %w", rule, err)
+	}
+	return r, nil
+}
+
+func validateSocket(socket string, extraKinds []string) error {
+	kind, name, found := strings.Cut(socket, ":")
+	if !found || name == "" {
+		return fmt.Errorf("invalid socket %s, expected <kind>:<name>", socket)
+	}
+
+	switch kind {
+	case "tcp":
+		port, err := strconv.Atoi(name)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid TCP port in %s", socket)
+		}
+		return nil
+	case "localabstract", "localreserved", "localfilesystem":
+		return nil
+	}
+	for _, extraKind := range extraKinds {
+		if kind == extraKind {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported socket %s", socket)
+}
+
+// ApplyPortRules sets up the rules and verifies that they are listed by adb.
+func (a *ADB) ApplyPortRules(serial string, rules []PortRule) error {
+	for _, rule := range rules {
+		cmd := a.cmdFactory.Create(
+			filepath.Join(a.androidHome, "platform-tools", "adb"),
+			[]string{"-s", serial, string(rule.Direction), rule.Listen, rule.Connect},
+			nil,
+		)
+		a.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+		if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+			return fmt.Errorf("adb %s: %s, output: %s", rule, err, out)
+		}
+	}
+
+	listed := map[PortRuleDirection][]PortRule{}
+	for _, rule := range rules {
+		if _, ok := listed[rule.Direction]; ok {
+			continue
+		}
+		list, err := a.ListPortRules(serial, rule.Direction)
+		if err != nil {
+			return err
+		}
+		listed[rule.Direction] = list
+	}
+
+	for _, rule := range rules {
+		if !containsPortRule(listed[rule.Direction], rule) {
+			return fmt.Errorf("rule %s is not listed by adb %s --list", rule, rule.Direction)
+		}
+	}
+	return nil
+}
+
+// ListPortRules returns the forward or reverse rules of the device.
+func (a *ADB) ListPortRules(serial string, direction PortRuleDirection) ([]PortRule, error) {
+	cmd := a.cmdFactory.Create(
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		[]string{"-s", serial, string(direction), "--list"},
+		nil,
+	)
+	a.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
+
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("adb %s --list: %s, output: %s", direction, err, out)
+	}
+	return parsePortRules(out, serial, direction), nil
+}
+
+// parsePortRules parses the --list output. Lines are in the <serial> <listen> <connect> format;
+// forward lists the rules of every device, reverse lists the rules of the selected one with a transport name
+// in the first column.
+func parsePortRules(out, serial string, direction PortRuleDirection) []PortRule {
+	var rules []PortRule
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		if direction == Forward && fields[0] != serial {
+			continue
+		}
+		rules = append(rules, PortRule{Direction: direction, Listen: fields[1], Connect: fields[2]})
+	}
+	return rules
+}
+
+func containsPortRule(rules []PortRule, rule PortRule) bool {
+	for _, r := range rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// PortRulesScript returns a shell script re-applying the rules, which are lost when the adb server restarts.
+func (a *ADB) PortRulesScript(serial string, rules []PortRule) string {
+	adbPath := filepath.Join(a.androidHome, "platform-tools", "adb")
+
+	lines := []string{
+		"#!/usr/bin/env bash",
+		fmt.Sprintf("# Re-applies the adb port rules of %s, e.g. after an adb server restart.", serial),
+		"set -e",
+	}
+	for _, rule := range rules {
+		lines = append(lines, shellquote.Join(adbPath, "-s", serial, string(rule.Direction), rule.Listen, rule.Connect))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
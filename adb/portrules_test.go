@@ -0,0 +1,100 @@
+package adb
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePortRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    PortRule
+		wantErr bool
+	}{
+		{
+			rule: "reverse tcp:8080 tcp:8080",
+			want: PortRule{Direction: Reverse, Listen: "tcp:8080", Connect: "tcp:8080"},
+		},
+		{
+			rule: "forward tcp:9222 localabstract:chrome_devtools_remote",
+			want: PortRule{Direction: Forward, Listen: "tcp:9222", Connect: "localabstract:chrome_devtools_remote"},
+		},
+		{
+			rule: "  reverse   localabstract:mock   tcp:3000 ",
+			want: PortRule{Direction: Reverse, Listen: "localabstract:mock", Connect: "tcp:3000"},
+		},
+		{
+			rule: "forward tcp:8700 jdwp:1234",
+			want: PortRule{Direction: Forward, Listen: "tcp:8700", Connect: "jdwp:1234"},
+		},
+		{rule: "reverse tcp:8700 jdwp:1234", wantErr: true},
+		{rule: "forward jdwp:1234 tcp:8700", wantErr: true},
+		{rule: "tcp:8080 tcp:8080", wantErr: true},
+		{rule: "proxy tcp:8080 tcp:8080", wantErr: true},
+		{rule: "reverse tcp:80800 tcp:8080", wantErr: true},
+		{rule: "reverse tcp: tcp:8080", wantErr: true},
+		{rule: "reverse udp:53 tcp:53", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			got, err := ParsePortRule(tt.rule)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, got, mustParsePortRule(t, got.String()))
+		})
+	}
+}
+
+func mustParsePortRule(t *testing.T, rule string) PortRule {
+	r, err := ParsePortRule(rule)
+	require.NoError(t, err)
+	return r
+}
+
+func TestParsePortRules(t *testing.T) {
+	forwardList := `emulator-5554 tcp:9222 localabstract:chrome_devtools_remote
+emulator-5556 tcp:9223 localabstract:chrome_devtools_remote`
+	require.Equal(t, []PortRule{
+		{Direction: Forward, Listen: "tcp:9222", Connect: "localabstract:chrome_devtools_remote"},
+	}, parsePortRules(forwardList, "emulator-5554", Forward))
+
+	reverseList := `host-12 tcp:8080 tcp:8080
+host-12 localabstract:mock tcp:3000`
+	require.Equal(t, []PortRule{
+		{Direction: Reverse, Listen: "tcp:8080", Connect: "tcp:8080"},
+		{Direction: Reverse, Listen: "localabstract:mock", Connect: "tcp:3000"},
+	}, parsePortRules(reverseList, "emulator-5554", Reverse))
+}
+
+func TestApplyPortRules(t *testing.T) {
+	rules := []PortRule{{Direction: Reverse, Listen: "tcp:8080", Connect: "tcp:8080"}}
+
+	adb := New("/fake/android/home", test.FakeCommandFactory{Stdout: "host-12 tcp:8080 tcp:8080"}, log.NewLogger())
+	require.NoError(t, adb.ApplyPortRules("emulator-5554", rules))
+
+	adb = New("/fake/android/home", test.FakeCommandFactory{Stdout: ""}, log.NewLogger())
+	require.EqualError(t, adb.ApplyPortRules("emulator-5554", rules), "rule reverse tcp:8080 tcp:8080 is not listed by adb reverse --list")
+}
+
+func TestPortRulesScript(t *testing.T) {
+	adb := New("/fake/android home", test.FakeCommandFactory{}, log.NewLogger())
+	script := adb.PortRulesScript("emulator-5554", []PortRule{
+		{Direction: Reverse, Listen: "tcp:8080", Connect: "tcp:8080"},
+		{Direction: Forward, Listen: "tcp:9222", Connect: "localabstract:chrome_devtools_remote"},
+	})
+
+	require.Equal(t, `#!/usr/bin/env bash
+# Re-applies the adb port rules of emulator-5554, e.g. after an adb server restart.
+set -e
+'/fake/android home/platform-tools/adb' -s emulator-5554 reverse tcp:8080 tcp:8080
+'/fake/android home/platform-tools/adb' -s emulator-5554 forward tcp:9222 localabstract:chrome_devtools_remote
+`, script)
+}
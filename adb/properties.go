@@ -0,0 +1,128 @@
+package adb
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Properties are the system properties of a device, as listed by `adb shell getprop`.
+type Properties map[string]string
+
+// Properties returns a snapshot of the device's system properties.
+func (a *ADB) Properties(serial string) (Properties, error) {
+	cmd := a.cmdFactory.Create(
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		[]string{"-s", serial, "shell", "getprop"},
+		nil,
+	)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("adb getprop: %s, output: %s", err, out)
+	}
+
+	return parseProperties(out)
+}
+
+// parseProperties parses the getprop output. Values might span multiple lines:
+//
+// [ro.build.version.sdk]: [34]
+// [ro.product.cpu.abilist]: [x86_64,arm64-v8a]
+// [ro.build.description]: [first line
+// second line]
+func parseProperties(out string) (Properties, error) {
+	properties := Properties{}
+
+	var (
+		key   string
+		value []string
+		open  bool
+	)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !open {
+			k, v, found := strings.Cut(line, "]: [")
+			if !found || !strings.HasPrefix(k, "[") {
+				continue
+			}
+			key, value, open = strings.TrimPrefix(k, "["), []string{v}, true
+		} else {
+			value = append(value, line)
+		}
+
+		if last := value[len(value)-1]; strings.HasSuffix(last, "]") {
+			value[len(value)-1] = strings.TrimSuffix(last, "]")
+			properties[key] = strings.Join(value, "\n")
+			open = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan getprop output: %w", err)
+	}
+
+	return properties, nil
+}
+
+// SDKInt returns the API level of the device (ro.build.version.sdk).
+func (p Properties) SDKInt() (int, error) {
+	value, ok := p["ro.build.version.sdk"]
+	if !ok {
+		return 0, fmt.Errorf("ro.build.version.sdk is not set")
+	}
+	sdkInt, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ro.build.version.sdk (%s): %w", value, err)
+	}
+	return sdkInt, nil
+}
+
+// Release returns the user-visible Android version, e.g. 14.
+func (p Properties) Release() string {
+	return p["ro.build.version.release"]
+}
+
+// ABIList returns the ABIs the device supports, the primary one first.
+func (p Properties) ABIList() []string {
+	if abiList := p["ro.product.cpu.abilist"]; abiList != "" {
+		return strings.Split(abiList, ",")
+	}
+	if abi := p["ro.product.cpu.abi"]; abi != "" {
+		return []string{abi}
+	}
+	return nil
+}
+
+// Fingerprint returns the build fingerprint, which identifies the exact system image.
+func (p Properties) Fingerprint() string {
+	return p["ro.build.fingerprint"]
+}
+
+// Locale returns the current locale as a BCP 47 language tag, e.g. en-US.
+func (p Properties) Locale() string {
+	if locale := p["persist.sys.locale"]; locale != "" {
+		return locale
+	}
+	return p["ro.product.locale"]
+}
+
+// Timezone returns the Olson ID of the device timezone, e.g. Europe/Budapest.
+func (p Properties) Timezone() string {
+	return p["persist.sys.timezone"]
+}
+
+// GPURenderer returns the EGL implementation used by the device, e.g. emulation or angle on emulators.
+func (p Properties) GPURenderer() string {
+	if renderer := p["ro.hardware.egl"]; renderer != "" {
+		return renderer
+	}
+	return p["ro.boot.hardware.egl"]
+}
+
+// IsEmulator reports whether the device is an emulator.
+func (p Properties) IsEmulator() bool {
+	return p["ro.kernel.qemu"] == "1" || p["ro.boot.qemu"] == "1"
+}
@@ -0,0 +1,58 @@
+package adb
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const getpropOutput = `[dalvik.vm.heapsize]: [576m]
+[persist.sys.locale]: [en-US]
+[persist.sys.timezone]: [Europe/Budapest]
+[ro.boot.hardware.egl]: [emulation]
+[ro.boot.qemu]: [1]
+[ro.build.description]: [sdk_gphone64_x86_64-userdebug 14 UE1A.230829.036 11228894 dev-keys
+second line]
+[ro.build.fingerprint]: [google/sdk_gphone64_x86_64/emu64xa:14/UE1A.230829.036/11228894:userdebug/dev-keys]
+[ro.build.version.release]: [14]
+[ro.build.version.sdk]: [34]
+[ro.product.cpu.abi]: [x86_64]
+[ro.product.cpu.abilist]: [x86_64,arm64-v8a]
+[ro.product.locale]: [en-GB]
+[sys.boot_completed]: []`
+
+func TestProperties(t *testing.T) {
+	adb := New("/fake/android/home", test.FakeCommandFactory{Stdout: getpropOutput}, log.NewLogger())
+
+	properties, err := adb.Properties("emulator-5554")
+	require.NoError(t, err)
+
+	require.Len(t, properties, 13)
+	assert.Equal(t, "sdk_gphone64_x86_64-userdebug 14 UE1A.230829.036 11228894 dev-keys\nsecond line", properties["ro.build.description"])
+	assert.Equal(t, "", properties["sys.boot_completed"])
+
+	sdkInt, err := properties.SDKInt()
+	require.NoError(t, err)
+	assert.Equal(t, 34, sdkInt)
+	assert.Equal(t, "14", properties.Release())
+	assert.Equal(t, []string{"x86_64", "arm64-v8a"}, properties.ABIList())
+	assert.Equal(t, "google/sdk_gphone64_x86_64/emu64xa:14/UE1A.230829.036/11228894:userdebug/dev-keys", properties.Fingerprint())
+	assert.Equal(t, "en-US", properties.Locale())
+	assert.Equal(t, "Europe/Budapest", properties.Timezone())
+	assert.Equal(t, "emulation", properties.GPURenderer())
+	assert.True(t, properties.IsEmulator())
+}
+
+func TestPropertiesFallbacks(t *testing.T) {
+	properties, err := parseProperties("[ro.product.cpu.abi]: [x86]\n[ro.product.locale]: [en-GB]\n[ro.build.version.sdk]: [unknown]")
+	require.NoError(t, err)
+
+	_, err = properties.SDKInt()
+	require.Error(t, err)
+	assert.Equal(t, []string{"x86"}, properties.ABIList())
+	assert.Equal(t, "en-GB", properties.Locale())
+	assert.False(t, properties.IsEmulator())
+}
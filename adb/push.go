@@ -0,0 +1,97 @@
+package adb
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mediaDirs are the shared storage directories indexed by the media scanner.
+var mediaDirs = []string{"DCIM", "Pictures", "Movies", "Music", "Download", "Ringtones", "Alarms", "Notifications", "Podcasts", "Audiobooks", "Recordings"}
+
+// sharedStorageRoots are the device paths of the primary shared storage.
+var sharedStorageRoots = []string{"/sdcard", "/storage/emulated/0", "/storage/self/primary"}
+
+// Push copies a local file to the device, creating the missing parent directories.
+func (a *ADB) Push(serial, local, remote string) error {
+	cmd := a.cmdFactory.Create(
+		filepath.Join(a.androidHome, "platform-tools", "adb"),
+		[]string{"-s", serial, "push", local, remote},
+		nil,
+	)
+	a.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return fmt.Errorf("adb push: %s, output: %s", err, out)
+	}
+	return nil
+}
+
+// FileSize returns the size of a file on the device in bytes.
+func (a *ADB) FileSize(serial, remote string) (int64, error) {
+	out, err := a.Shell(serial, "stat", "-c", "%s", remote)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected stat output for %s: %s", remote, out)
+	}
+	return size, nil
+}
+
+// PushVerified pushes a local file to the device, and checks that the device copy has the same size.
+// Files pushed into a media directory are announced to the media scanner, so that they show up in the gallery.
+func (a *ADB) PushVerified(serial, local, remote string) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+
+	if err := a.Push(serial, local, remote); err != nil {
+		return err
+	}
+
+	size, err := a.FileSize(serial, remote)
+	if err != nil {
+		return err
+	}
+	if size != info.Size() {
+		return fmt.Errorf("size mismatch for %s: pushed %d bytes, device has %d bytes", remote, info.Size(), size)
+	}
+
+	if IsMediaPath(remote) {
+		return a.ScanMedia(serial, remote)
+	}
+	return nil
+}
+
+// ScanMedia asks the media scanner to index a file.
+func (a *ADB) ScanMedia(serial, remote string) error {
+	_, err := a.Shell(serial, "am", "broadcast", "-a", "android.intent.action.MEDIA_SCANNER_SCAN_FILE", "-d", "file://"+remote)
+	return err
+}
+
+// IsMediaPath reports whether the device path is inside one of the media directories of the shared storage.
+func IsMediaPath(remote string) bool {
+	remote = path.Clean(remote)
+	for _, root := range sharedStorageRoots {
+		rel, found := strings.CutPrefix(remote, root+"/")
+		if !found {
+			continue
+		}
+
+		topDir, _, _ := strings.Cut(rel, "/")
+		for _, dir := range mediaDirs {
+			if topDir == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package adb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsMediaPath(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   bool
+	}{
+		{remote: "/sdcard/DCIM/Camera/photo.jpg", want: true},
+		{remote: "/sdcard/Pictures/image.png", want: true},
+		{remote: "/storage/emulated/0/Movies/clip.mp4", want: true},
+		{remote: "/sdcard/Android/data/com.example/files/data.json", want: false},
+		{remote: "/sdcard/fixture.json", want: false},
+		{remote: "/data/local/tmp/Pictures/image.png", want: false},
+		{remote: "/sdcard/PicturesBackup/image.png", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			require.Equal(t, tt.want, IsMediaPath(tt.remote))
+		})
+	}
+}
+
+func TestFileSize(t *testing.T) {
+	adb := New("/fake/android/home", test.FakeCommandFactory{Stdout: "1024"}, log.NewLogger())
+	size, err := adb.FileSize("emulator-5554", "/sdcard/fixture.json")
+	require.NoError(t, err)
+	require.Equal(t, int64(1024), size)
+
+	adb = New("/fake/android/home", test.FakeCommandFactory{Stdout: "stat: '/sdcard/missing': No such file or directory"}, log.NewLogger())
+	_, err = adb.FileSize("emulator-5554", "/sdcard/missing")
+	require.Error(t, err)
+}
+
+func TestPushVerified(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "photo.jpg")
+	require.NoError(t, os.WriteFile(local, []byte("0123456789"), 0644))
+
+	tests := []struct {
+		name      string
+		statSize  string
+		exitCode  int
+		wantError bool
+	}{
+		{
+			name:     "sizes match",
+			statSize: "10",
+		},
+		{
+			name:      "size mismatch",
+			statSize:  "4",
+			wantError: true,
+		},
+		{
+			name:      "push failure",
+			exitCode:  1,
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adb := New("/fake/android/home", test.FakeCommandFactory{Stdout: tt.statSize, ExitCode: tt.exitCode}, log.NewLogger())
+
+			err := adb.PushVerified("emulator-5554", local, "/sdcard/DCIM/photo.jpg")
+			if tt.wantError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
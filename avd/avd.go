@@ -0,0 +1,67 @@
+package avd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// HomeDir returns the directory holding the AVDs, following the same lookup order as the emulator and avdmanager.
+func HomeDir(envRepo env.Repository) (string, error) {
+	if avdHome := envRepo.Get("ANDROID_AVD_HOME"); avdHome != "" {
+		return avdHome, nil
+	}
+	if emulatorHome := envRepo.Get("ANDROID_EMULATOR_HOME"); emulatorHome != "" {
+		return filepath.Join(emulatorHome, "avd"), nil
+	}
+	if userHome := envRepo.Get("ANDROID_USER_HOME"); userHome != "" {
+		return filepath.Join(userHome, "avd"), nil
+	}
+	if sdkHome := envRepo.Get("ANDROID_SDK_HOME"); sdkHome != "" {
+		return filepath.Join(sdkHome, ".android", "avd"), nil
+	}
+
+	home := envRepo.Get("HOME")
+	if home == "" {
+		var err error
+		home, err = os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("resolve home directory: %w", err)
+		}
+	}
+	return filepath.Join(home, ".android", "avd"), nil
+}
+
+// Dir returns the content directory of the AVD with the given ID.
+func Dir(avdHome, id string) string {
+	return filepath.Join(avdHome, id+".avd")
+}
+
+// LockFiles returns the emulator lock files (and lock directories) in the AVD content directory.
+func LockFiles(avdDir string) ([]string, error) {
+	locks, err := filepath.Glob(filepath.Join(avdDir, "*.lock"))
+	if err != nil {
+		return nil, fmt.Errorf("list lock files in %s: %w", avdDir, err)
+	}
+	return locks, nil
+}
+
+// RemoveLockFiles deletes the emulator lock files left behind in the AVD content directory
+// and returns the removed paths.
+func RemoveLockFiles(avdDir string) ([]string, error) {
+	locks, err := LockFiles(avdDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, lock := range locks {
+		if err := os.RemoveAll(lock); err != nil {
+			return removed, fmt.Errorf("remove lock %s: %w", lock, err)
+		}
+		removed = append(removed, lock)
+	}
+	return removed, nil
+}
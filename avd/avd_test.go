@@ -0,0 +1,75 @@
+package avd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHomeDir(t *testing.T) {
+	tests := []struct {
+		name string
+		envs map[string]string
+		want string
+	}{
+		{
+			name: "ANDROID_AVD_HOME wins",
+			envs: map[string]string{"ANDROID_AVD_HOME": "/avd", "ANDROID_EMULATOR_HOME": "/emu", "HOME": "/home/user"},
+			want: "/avd",
+		},
+		{
+			name: "ANDROID_EMULATOR_HOME",
+			envs: map[string]string{"ANDROID_EMULATOR_HOME": "/emu", "ANDROID_USER_HOME": "/user"},
+			want: "/emu/avd",
+		},
+		{
+			name: "ANDROID_USER_HOME",
+			envs: map[string]string{"ANDROID_USER_HOME": "/user", "ANDROID_SDK_HOME": "/sdk"},
+			want: "/user/avd",
+		},
+		{
+			name: "ANDROID_SDK_HOME",
+			envs: map[string]string{"ANDROID_SDK_HOME": "/sdk"},
+			want: "/sdk/.android/avd",
+		},
+		{
+			name: "HOME",
+			envs: map[string]string{"HOME": "/home/user"},
+			want: "/home/user/.android/avd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"ANDROID_AVD_HOME", "ANDROID_EMULATOR_HOME", "ANDROID_USER_HOME", "ANDROID_SDK_HOME", "HOME"} {
+				t.Setenv(key, tt.envs[key])
+			}
+
+			got, err := HomeDir(env.NewRepository())
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRemoveLockFiles(t *testing.T) {
+	avdDir := Dir(t.TempDir(), "emulator")
+	require.NoError(t, os.MkdirAll(filepath.Join(avdDir, "hardware-qemu.ini.lock"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(avdDir, "multiinstance.lock"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(avdDir, "config.ini"), nil, 0644))
+
+	removed, err := RemoveLockFiles(avdDir)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		filepath.Join(avdDir, "hardware-qemu.ini.lock"),
+		filepath.Join(avdDir, "multiinstance.lock"),
+	}, removed)
+
+	locks, err := LockFiles(avdDir)
+	require.NoError(t, err)
+	require.Empty(t, locks)
+	require.FileExists(t, filepath.Join(avdDir, "config.ini"))
+}
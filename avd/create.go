@@ -0,0 +1,219 @@
+package avd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/devices"
+)
+
+// ConfigFileName is the name of the AVD configuration in the AVD content directory.
+const ConfigFileName = "config.ini"
+
+var validID = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// CreateConfig describes the AVD to create.
+type CreateConfig struct {
+	ID     string
+	Image  SystemImage
+	Device devices.Device
+	// SDCardSize is the size of the SD card image in mksdcard format (e.g. 2048M), empty for no SD card.
+	SDCardSize string
+}
+
+// Creator creates AVDs the way avdmanager does, without starting a JVM.
+type Creator struct {
+	androidHome  string
+	avdHome      string
+	mksdcardPath string
+	cmdFactory   command.Factory
+	logger       log.Logger
+}
+
+// NewCreator returns a Creator creating the AVDs in avdHome from the system images of androidHome.
+// SD card images are created with the mksdcard tool of the emulator package.
+func NewCreator(androidHome, avdHome string, cmdFactory command.Factory, logger log.Logger) Creator {
+	return Creator{
+		androidHome:  androidHome,
+		avdHome:      avdHome,
+		mksdcardPath: filepath.Join(androidHome, "emulator", "mksdcard"),
+		cmdFactory:   cmdFactory,
+		logger:       logger,
+	}
+}
+
+// Create writes the <id>.ini and the <id>.avd content directory of the AVD, replacing an existing AVD with the
+// same ID. The content directory gets the config.ini, the initial user data of the system image and the SD card.
+func (c Creator) Create(cfg CreateConfig) error {
+	if !validID.MatchString(cfg.ID) {
+		return fmt.Errorf("invalid AVD ID: %s, allowed characters are a-z A-Z 0-9 . _ -", cfg.ID)
+	}
+
+	avdDir := Dir(c.avdHome, cfg.ID)
+	iniPath := filepath.Join(c.avdHome, cfg.ID+".ini")
+	if err := os.Remove(iniPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove existing AVD: %w", err)
+	}
+	if err := os.RemoveAll(avdDir); err != nil {
+		return fmt.Errorf("remove existing AVD: %w", err)
+	}
+	if err := c.create(cfg, avdDir, iniPath); err != nil {
+		if cleanupErr := os.RemoveAll(avdDir); cleanupErr != nil {
+			c.logger.Warnf("Failed to clean up %s: %s", avdDir, cleanupErr)
+		}
+		return err
+	}
+
+	c.logger.Printf("Created AVD %s with %s (%dx%d, %d dpi) and %s", cfg.ID, cfg.Device.Name, cfg.Device.ScreenWidth, cfg.Device.ScreenHeight, cfg.Device.Density, cfg.Image.Dir)
+	return nil
+}
+
+func (c Creator) create(cfg CreateConfig, avdDir, iniPath string) error {
+	if err := os.MkdirAll(avdDir, 0755); err != nil {
+		return err
+	}
+
+	userData := filepath.Join(c.androidHome, filepath.FromSlash(cfg.Image.Dir), "userdata.img")
+	if err := copyFile(userData, filepath.Join(avdDir, "userdata.img")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("copy initial user data: %w", err)
+	}
+
+	if cfg.SDCardSize != "" {
+		cmd := c.cmdFactory.Create(c.mksdcardPath, []string{cfg.SDCardSize, filepath.Join(avdDir, "sdcard.img")}, nil)
+		if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+			return fmt.Errorf("create SD card: %w, output: %s", err, out)
+		}
+	}
+
+	if err := writeINI(filepath.Join(avdDir, ConfigFileName), configProperties(cfg)); err != nil {
+		return err
+	}
+	return writeINI(iniPath, c.avdProperties(cfg, avdDir))
+}
+
+// avdProperties are the properties of <id>.ini, pointing to the content directory of the AVD.
+func (c Creator) avdProperties(cfg CreateConfig, avdDir string) map[string]string {
+	properties := map[string]string{
+		"avd.ini.encoding": "UTF-8",
+		"path":             avdDir,
+		"target":           cfg.Image.Target(),
+	}
+	// The relative path is resolved against the Android user home, the parent of the default AVD home.
+	if filepath.Base(c.avdHome) == "avd" {
+		properties["path.rel"] = "avd/" + filepath.Base(avdDir)
+	}
+	return properties
+}
+
+// configProperties are the properties of config.ini, with the same keys and values avdmanager writes.
+func configProperties(cfg CreateConfig) map[string]string {
+	image, device := cfg.Image, cfg.Device
+	properties := map[string]string{
+		"AvdId":                         cfg.ID,
+		"PlayStore.enabled":             strconv.FormatBool(strings.Contains(image.TagID, "playstore")),
+		"abi.type":                      image.ABI,
+		"avd.ini.displayname":           strings.ReplaceAll(cfg.ID, "_", " "),
+		"avd.ini.encoding":              "UTF-8",
+		"hw.cpu.arch":                   cpuArch(image.ABI),
+		"image.androidVersion.api":      image.APILevel,
+		"image.androidVersion.codename": image.CodeName,
+		"image.sysdir.1":                image.Dir + "/",
+		"tag.display":                   image.TagDisplay,
+		"tag.id":                        image.TagID,
+		"tag.ids":                       image.TagIDs,
+		"hw.device.manufacturer":        device.Manufacturer,
+		"hw.device.name":                device.ID,
+		"hw.lcd.width":                  strconv.Itoa(device.ScreenWidth),
+		"hw.lcd.height":                 strconv.Itoa(device.ScreenHeight),
+		"hw.lcd.density":                strconv.Itoa(device.Density),
+		"hw.keyboard":                   yesNo(device.Keyboard),
+		"hw.dPad":                       yesNo(device.DPad),
+		"hw.trackBall":                  yesNo(device.TrackBall),
+		"hw.mainKeys":                   yesNo(device.HardwareButtons),
+		"hw.accelerometer":              yesNo(device.Accelerometer),
+		"hw.sensors.orientation":        yesNo(device.Gyroscope),
+		"hw.sensors.proximity":          yesNo(device.ProximitySensor),
+		"hw.gps":                        yesNo(device.GPS),
+		"hw.audioInput":                 yesNo(device.Microphone),
+		"hw.battery":                    yesNo(device.Battery),
+		"hw.camera.back":                "none",
+		"hw.camera.front":               "none",
+		"hw.sdCard":                     yesNo(cfg.SDCardSize != ""),
+		"sdcard.size":                   cfg.SDCardSize,
+	}
+	if image.ABI == "armeabi-v7a" {
+		properties["hw.cpu.model"] = "cortex-a8"
+	}
+	if device.BackCamera {
+		properties["hw.camera.back"] = "virtualscene"
+	}
+	if device.FrontCamera {
+		properties["hw.camera.front"] = "emulated"
+	}
+	if device.RAMMegabytes > 0 {
+		properties["hw.ramSize"] = strconv.Itoa(device.RAMMegabytes)
+	}
+	return properties
+}
+
+func cpuArch(abi string) string {
+	switch abi {
+	case "armeabi-v7a":
+		return "arm"
+	case "arm64-v8a":
+		return "arm64"
+	}
+	return abi
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// writeINI writes the properties sorted by key, leaving out the empty ones, like avdmanager does.
+func writeINI(pth string, properties map[string]string) error {
+	var keys []string
+	for key, value := range properties {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key + "=" + properties[key] + "\n")
+	}
+	return os.WriteFile(pth, []byte(b.String()), 0644)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
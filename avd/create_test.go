@@ -0,0 +1,139 @@
+package avd
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/devices"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// newTestSDK returns an Android SDK with a mksdcard recording the requested size into the SD card image.
+func newTestSDK(t *testing.T, mksdcard string) string {
+	androidHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(androidHome, "emulator"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(androidHome, "emulator", "mksdcard"), []byte(mksdcard), 0755))
+	return androidHome
+}
+
+func writeImage(t *testing.T, androidHome, imageDir string, files map[string]string) {
+	dir := filepath.Join(androidHome, imageDir)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+}
+
+func lookupBuiltin(t *testing.T, id string) devices.Device {
+	builtin, err := devices.Builtin()
+	require.NoError(t, err)
+	device, err := devices.NewCatalog(builtin...).Lookup(id)
+	require.NoError(t, err)
+	return device
+}
+
+func requireGolden(t *testing.T, name, got string) {
+	golden := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, os.WriteFile(golden, []byte(got), 0644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	require.Equal(t, string(want), got)
+}
+
+func TestCreate(t *testing.T) {
+	androidHome := newTestSDK(t, "#!/bin/sh\nprintf '%s' \"$1\" > \"$2\"\n")
+	imageDir := filepath.Join("system-images", "android-34", "google_apis", "x86_64")
+	writeImage(t, androidHome, imageDir, map[string]string{
+		"source.properties": "Pkg.Desc=Google APIs Intel x86_64 Atom System Image\nPkg.Revision=12\n" +
+			"AndroidVersion.ApiLevel=34\nSystemImage.Abi=x86_64\nSystemImage.TagId=google_apis\n" +
+			"SystemImage.TagDisplay=Google APIs\nPkg.Path=system-images\\;android-34\\;google_apis\\;x86_64\n",
+		"build.prop":   "ro.build.version.sdk=34\nro.product.cpu.abi=x86_64\n",
+		"userdata.img": "user data",
+	})
+	avdHome := filepath.Join(t.TempDir(), "avd")
+
+	image, err := ReadSystemImage(androidHome, imageDir)
+	require.NoError(t, err)
+	device := lookupBuiltin(t, "pixel")
+
+	creator := NewCreator(androidHome, avdHome, command.NewFactory(env.NewRepository()), log.NewLogger())
+	err = creator.Create(CreateConfig{ID: "Pixel_API_34", Image: image, Device: device, SDCardSize: "512M"})
+	require.NoError(t, err)
+
+	avdDir := Dir(avdHome, "Pixel_API_34")
+	config, err := os.ReadFile(filepath.Join(avdDir, ConfigFileName))
+	require.NoError(t, err)
+	requireGolden(t, "config.ini", string(config))
+
+	ini, err := os.ReadFile(filepath.Join(avdHome, "Pixel_API_34.ini"))
+	require.NoError(t, err)
+	requireGolden(t, "Pixel_API_34.ini", strings.ReplaceAll(string(ini), avdHome, "$AVD_HOME"))
+
+	userData, err := os.ReadFile(filepath.Join(avdDir, "userdata.img"))
+	require.NoError(t, err)
+	require.Equal(t, "user data", string(userData))
+	sdCard, err := os.ReadFile(filepath.Join(avdDir, "sdcard.img"))
+	require.NoError(t, err)
+	require.Equal(t, "512M", string(sdCard))
+
+	// Creating it again replaces the AVD.
+	require.NoError(t, os.WriteFile(filepath.Join(avdDir, "userdata-qemu.img"), nil, 0644))
+	require.NoError(t, creator.Create(CreateConfig{ID: "Pixel_API_34", Image: image, Device: device}))
+	require.NoFileExists(t, filepath.Join(avdDir, "userdata-qemu.img"))
+	require.NoFileExists(t, filepath.Join(avdDir, "sdcard.img"))
+}
+
+func TestCreate_BuildPropFallback(t *testing.T) {
+	androidHome := newTestSDK(t, "#!/bin/sh\nexit 1\n")
+	imageDir := filepath.Join("system-images", "android-VanillaIceCream", "default", "armeabi-v7a")
+	writeImage(t, androidHome, imageDir, map[string]string{
+		"source.properties": "Pkg.Revision=1\nAndroidVersion.CodeName=VanillaIceCream\n",
+		"build.prop":        "ro.build.version.sdk=34\nro.product.cpu.abi=armeabi-v7a\n",
+	})
+	avdHome := t.TempDir()
+
+	image, err := ReadSystemImage(androidHome, imageDir)
+	require.NoError(t, err)
+	require.Equal(t, SystemImage{
+		Dir:        "system-images/android-VanillaIceCream/default/armeabi-v7a",
+		APILevel:   "34",
+		CodeName:   "VanillaIceCream",
+		ABI:        "armeabi-v7a",
+		TagID:      "default",
+		TagDisplay: "Default Android System Image",
+	}, image)
+	require.Equal(t, "android-VanillaIceCream", image.Target())
+
+	properties := configProperties(CreateConfig{ID: "emulator", Image: image, Device: devices.Device{ID: "custom", ScreenWidth: 480, ScreenHeight: 800, Density: 240}})
+	require.Equal(t, "arm", properties["hw.cpu.arch"])
+	require.Equal(t, "cortex-a8", properties["hw.cpu.model"])
+	require.Equal(t, "no", properties["hw.sdCard"])
+	require.Equal(t, "none", properties["hw.camera.back"])
+	require.NotContains(t, properties, "hw.ramSize")
+
+	// A failing mksdcard leaves no AVD behind.
+	device := lookupBuiltin(t, "pixel_tablet")
+	creator := NewCreator(androidHome, avdHome, command.NewFactory(env.NewRepository()), log.NewLogger())
+	err = creator.Create(CreateConfig{ID: "emulator", Image: image, Device: device, SDCardSize: "2048M"})
+	require.ErrorContains(t, err, "create SD card")
+	require.NoDirExists(t, Dir(avdHome, "emulator"))
+	require.NoFileExists(t, filepath.Join(avdHome, "emulator.ini"))
+}
+
+func TestCreate_InvalidInput(t *testing.T) {
+	creator := NewCreator(t.TempDir(), t.TempDir(), command.NewFactory(env.NewRepository()), log.NewLogger())
+	require.ErrorContains(t, creator.Create(CreateConfig{ID: "my emulator"}), "invalid AVD ID")
+
+	_, err := ReadSystemImage(t.TempDir(), "system-images/android-34/google_apis/x86_64")
+	require.ErrorContains(t, err, "read system image properties")
+}
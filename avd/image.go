@@ -0,0 +1,119 @@
+package avd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SystemImage is an installed system image, as described by its source.properties and build.prop.
+type SystemImage struct {
+	// Dir is the image directory relative to the Android SDK root, e.g. system-images/android-34/google_apis/x86_64.
+	Dir        string
+	APILevel   string
+	CodeName   string
+	ABI        string
+	TagID      string
+	TagDisplay string
+	// TagIDs lists every tag of the image, e.g. google_apis,page_size_16kb, if the image has more than one.
+	TagIDs string
+}
+
+// ReadSystemImage reads the installed system image in the imageDir directory of the Android SDK.
+// source.properties is written by the SDK tools when installing the image, build.prop is only a fallback
+// for the API level and the ABI of images installed by other means.
+func ReadSystemImage(androidHome, imageDir string) (SystemImage, error) {
+	dir := filepath.Join(androidHome, imageDir)
+	source, err := readProperties(filepath.Join(dir, "source.properties"))
+	if err != nil {
+		return SystemImage{}, fmt.Errorf("read system image properties: %w", err)
+	}
+	build, err := readProperties(filepath.Join(dir, "build.prop"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return SystemImage{}, fmt.Errorf("read system image build properties: %w", err)
+	}
+
+	image := SystemImage{
+		Dir:        filepath.ToSlash(imageDir),
+		APILevel:   firstNonEmpty(source["AndroidVersion.ApiLevel"], build["ro.build.version.sdk"]),
+		CodeName:   source["AndroidVersion.CodeName"],
+		ABI:        firstNonEmpty(source["SystemImage.Abi"], build["ro.product.cpu.abi"]),
+		TagID:      source["SystemImage.TagId"],
+		TagDisplay: source["SystemImage.TagDisplay"],
+		TagIDs:     source["SystemImage.TagIds"],
+	}
+	if image.APILevel == "" || image.ABI == "" {
+		return SystemImage{}, fmt.Errorf("system image in %s has no API level or ABI", dir)
+	}
+	if image.TagID == "" {
+		image.TagID = "default"
+	}
+	if image.TagDisplay == "" {
+		image.TagDisplay = "Default Android System Image"
+	}
+	return image, nil
+}
+
+// Target returns the platform the image is built for, e.g. android-34, as avdmanager writes it in the AVD ini.
+func (i SystemImage) Target() string {
+	if i.CodeName != "" {
+		return "android-" + i.CodeName
+	}
+	return "android-" + i.APILevel
+}
+
+// readProperties reads a Java properties file, the format of source.properties and build.prop.
+func readProperties(pth string) (map[string]string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	properties := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		properties[strings.TrimSpace(key)] = unescapeProperty(strings.TrimSpace(value))
+	}
+	return properties, scanner.Err()
+}
+
+// unescapeProperty removes the backslashes escaping characters like : and = in the property values.
+func unescapeProperty(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var b strings.Builder
+	escaped := false
+	for _, r := range value {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
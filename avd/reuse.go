@@ -0,0 +1,93 @@
+package avd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Exists reports whether the AVD with the given ID exists, either its <id>.ini or its content directory.
+func Exists(avdHome, id string) (bool, error) {
+	for _, pth := range []string{filepath.Join(avdHome, id+".ini"), Dir(avdHome, id)} {
+		_, err := os.Stat(pth)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Requirements are the config.ini values an existing AVD must have to be reused, empty values aren't checked.
+type Requirements struct {
+	// ImageDir is the system image directory relative to the Android SDK root.
+	ImageDir string
+	ABI      string
+	Tag      string
+	Device   string
+}
+
+// Mismatches compares the config.ini of the AVD in avdDir with the requirements,
+// and returns a description of every differing value.
+func Mismatches(avdDir string, req Requirements) ([]string, error) {
+	config, err := readProperties(filepath.Join(avdDir, ConfigFileName))
+	if err != nil {
+		return nil, fmt.Errorf("read AVD config: %w", err)
+	}
+
+	checks := []struct {
+		key, want string
+	}{
+		{"image.sysdir.1", strings.TrimSuffix(filepath.ToSlash(req.ImageDir), "/")},
+		{"abi.type", req.ABI},
+		{"tag.id", req.Tag},
+		{"hw.device.name", req.Device},
+	}
+
+	var mismatches []string
+	for _, check := range checks {
+		if check.want == "" {
+			continue
+		}
+		got := config[check.key]
+		if check.key == "image.sysdir.1" {
+			got = strings.TrimSuffix(got, "/")
+		}
+		if got != check.want {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %q, expected %q", check.key, got, check.want))
+		}
+	}
+	return mismatches, nil
+}
+
+// RAMMegabytes returns the guest RAM size (hw.ramSize) of the AVD in avdDir, 0 if it isn't set.
+// The size is in megabytes, with an optional M or G suffix.
+func RAMMegabytes(avdDir string) (int, error) {
+	config, err := readProperties(filepath.Join(avdDir, ConfigFileName))
+	if err != nil {
+		return 0, fmt.Errorf("read AVD config: %w", err)
+	}
+
+	value := strings.TrimSpace(config["hw.ramSize"])
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "G"):
+		value, multiplier = strings.TrimSuffix(value, "G"), 1024
+	case strings.HasSuffix(value, "M"):
+		value = strings.TrimSuffix(value, "M")
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hw.ramSize: %q", config["hw.ramSize"])
+	}
+	return size * multiplier, nil
+}
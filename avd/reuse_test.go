@@ -0,0 +1,73 @@
+package avd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExists(t *testing.T) {
+	avdHome := t.TempDir()
+
+	exists, err := Exists(avdHome, "emulator")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// A leftover content directory without its ini still takes the ID.
+	require.NoError(t, os.MkdirAll(Dir(avdHome, "emulator"), 0755))
+	exists, err = Exists(avdHome, "emulator")
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestMismatches(t *testing.T) {
+	avdDir := Dir(t.TempDir(), "emulator")
+	require.NoError(t, os.MkdirAll(avdDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(avdDir, ConfigFileName), []byte(
+		"abi.type=x86_64\nhw.device.name=pixel\nimage.sysdir.1=system-images/android-34/google_apis/x86_64/\ntag.id=google_apis\n"), 0644))
+
+	req := Requirements{
+		ImageDir: filepath.Join("system-images", "android-34", "google_apis", "x86_64"),
+		ABI:      "x86_64",
+		Tag:      "google_apis",
+		Device:   "pixel",
+	}
+	mismatches, err := Mismatches(avdDir, req)
+	require.NoError(t, err)
+	require.Empty(t, mismatches)
+
+	req.ImageDir = filepath.Join("system-images", "android-35", "google_apis", "x86_64")
+	req.Device = "pixel_7"
+	mismatches, err = Mismatches(avdDir, req)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`image.sysdir.1 is "system-images/android-34/google_apis/x86_64", expected "system-images/android-35/google_apis/x86_64"`,
+		`hw.device.name is "pixel", expected "pixel_7"`,
+	}, mismatches)
+
+	_, err = Mismatches(Dir(t.TempDir(), "missing"), req)
+	require.ErrorContains(t, err, "read AVD config")
+}
+
+func TestRAMMegabytes(t *testing.T) {
+	avdDir := Dir(t.TempDir(), "emulator")
+	require.NoError(t, os.MkdirAll(avdDir, 0755))
+
+	for config, want := range map[string]int{
+		"hw.ramSize=1536\n":  1536,
+		"hw.ramSize=2048M\n": 2048,
+		"hw.ramSize=4G\n":    4096,
+		"abi.type=x86_64\n":  0,
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(avdDir, ConfigFileName), []byte(config), 0644))
+		got, err := RAMMegabytes(avdDir)
+		require.NoError(t, err)
+		require.Equal(t, want, got, config)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(avdDir, ConfigFileName), []byte("hw.ramSize=lots\n"), 0644))
+	_, err := RAMMegabytes(avdDir)
+	require.ErrorContains(t, err, "invalid hw.ramSize")
+}
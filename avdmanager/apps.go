@@ -0,0 +1,94 @@
+package avdmanager
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+)
+
+const (
+	apkInstallAttempts   = 3
+	apkInstallRetryDelay = 5 * time.Second
+)
+
+// resolveApps expands the apks_to_install lines. Every APK matched by a line is installed as a separate app,
+// except on lines listing comma-separated paths or globs: their APKs are installed as the split APKs of one app.
+func resolveApps(lines []string) ([][]string, error) {
+	var apps [][]string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var apks []string
+		for _, pattern := range strings.Split(line, ",") {
+			matches, err := globAPKs(strings.TrimSpace(pattern))
+			if err != nil {
+				return nil, err
+			}
+			apks = append(apks, matches...)
+		}
+
+		if !strings.Contains(line, ",") {
+			for _, apk := range apks {
+				apps = append(apps, []string{apk})
+			}
+			continue
+		}
+		apps = append(apps, apks)
+	}
+	return apps, nil
+}
+
+func globAPKs(pattern string) ([]string, error) {
+	apks, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	if len(apks) == 0 {
+		return nil, fmt.Errorf("no APK found at %s", pattern)
+	}
+	for _, apk := range apks {
+		if filepath.Ext(apk) != ".apk" {
+			return nil, fmt.Errorf("%s is not an APK", apk)
+		}
+	}
+	sort.Strings(apks)
+	return apks, nil
+}
+
+// installApps installs every app and prints a per-app report, it fails if any of the installs failed.
+// It stops at the first app after ctx is done.
+func (r Runner) installApps(ctx context.Context, adbClient adb.ADB, serial string, apps [][]string, opts adb.InstallOptions) error {
+	var results []adb.InstallResult
+	for _, apks := range apps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		results = append(results, adbClient.Install(ctx, serial, apks, opts))
+	}
+
+	r.logger.Println()
+	r.logger.Infof("Installed APKs")
+	failed := 0
+	for _, result := range results {
+		name := strings.Join(result.APKs, ", ")
+		if result.Err != nil {
+			failed++
+			r.logger.Errorf("%s: %s (attempts: %d)", name, result.Err, result.Attempts)
+		} else {
+			r.logger.Donef("%s (attempts: %d)", name, result.Attempts)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to install %d of %d app(s)", failed, len(results))
+	}
+	return nil
+}
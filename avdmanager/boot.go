@@ -0,0 +1,294 @@
+package avdmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+	"github.com/bitrise-steplib/steps-avd-manager/avd"
+	"github.com/bitrise-steplib/steps-avd-manager/recovery"
+)
+
+const (
+	startDevicePhase = "Starting device"
+	softwareGPUMode  = "swiftshader_indirect"
+)
+
+type bootOutcome string
+
+const (
+	bootOutcomeBooted      bootOutcome = "booted"
+	bootOutcomeFault       bootOutcome = "fault in emulator log"
+	bootOutcomeExitedEarly bootOutcome = "emulator exited early"
+	bootOutcomeTimedOut    bootOutcome = "boot timed out"
+	bootOutcomeInterrupted bootOutcome = "interrupted"
+	bootOutcomeFailed      bootOutcome = "failed"
+)
+
+// retryableOutcomes maps the boot outcomes worth retrying to the failure assumed when the emulator log
+// doesn't point to a more specific one.
+var retryableOutcomes = map[bootOutcome]recovery.Failure{
+	bootOutcomeFault:       recovery.FailureKernelFault,
+	bootOutcomeExitedEarly: recovery.FailureExitedEarly,
+	bootOutcomeTimedOut:    recovery.FailureTimeout,
+}
+
+type bootConfig struct {
+	emulatorPath  string
+	args          []string
+	logPath       string
+	avdDir        string
+	timeout       time.Duration
+	checkInterval time.Duration
+	maxAttempts   int
+	policy        recovery.Policy
+	recreateAVD   func(ctx context.Context) error
+}
+
+type bootAttempt struct {
+	outcome     bootOutcome
+	serial      string
+	emulatorLog string
+	err         error
+}
+
+// bootEmulator starts the emulator and waits for the new device to come online. Failed attempts are classified
+// and followed by the recovery action the policy picks for them, until the attempt budget runs out.
+func (r Runner) bootEmulator(ctx context.Context, adbClient adb.ADB, cfg bootConfig, runningDevices adb.Devices) (string, error) {
+	history := recovery.NewHistory(cfg.policy)
+	defer r.printRecoveryHistory(history)
+
+	args := cfg.args
+	for attempt := 1; ; attempt++ {
+		attemptCfg := cfg
+		attemptCfg.args = args
+
+		startTime := r.clock.Now()
+		result := r.startEmulator(ctx, adbClient, attemptCfg, runningDevices)
+		r.logger.Printf("Boot attempt %d/%d: %s (%s)", attempt, cfg.maxAttempts, result.outcome, r.clock.Now().Sub(startTime).Round(time.Second))
+
+		if result.outcome == bootOutcomeBooted {
+			return result.serial, nil
+		}
+		fallback, retryable := retryableOutcomes[result.outcome]
+		if !retryable {
+			return "", result.err
+		}
+		if attempt >= cfg.maxAttempts {
+			return "", BootError{Attempts: attempt, Err: result.err}
+		}
+
+		record := history.Next(attempt, recovery.Classify(fallback, result.emulatorLog))
+		if record.Action == recovery.ActionFail {
+			return "", result.err
+		}
+		r.logger.Warnf("Boot attempt %d failed with %s, running recovery action %s in %s", attempt, record.Failure, record.Action, record.Backoff)
+
+		if err := r.sleepContext(ctx, record.Backoff); err != nil {
+			return "", InterruptedError{Phase: startDevicePhase, Cause: err}
+		}
+
+		var err error
+		args, err = r.runRecoveryAction(ctx, record.Action, adbClient, cfg, args)
+		if err != nil {
+			return "", fmt.Errorf("recovery action %s failed: %w", record.Action, err)
+		}
+	}
+}
+
+// runRecoveryAction prepares the next boot attempt and returns the emulator args to use for it.
+func (r Runner) runRecoveryAction(ctx context.Context, action recovery.Action, adbClient adb.ADB, cfg bootConfig, args []string) ([]string, error) {
+	switch action {
+	case recovery.ActionRetry:
+	case recovery.ActionRetryWithSoftwareGPU:
+		r.logger.Printf("Switching to software rendering: -gpu %s", softwareGPUMode)
+		return withGPUMode(args, softwareGPUMode), nil
+	case recovery.ActionRetryAfterADBRestart:
+		r.logger.Printf("Restarting adb server")
+		if err := adbClient.KillServer(); err != nil {
+			return nil, err
+		}
+	case recovery.ActionRetryAfterLockCleanup:
+		removed, err := avd.RemoveLockFiles(cfg.avdDir)
+		if err != nil {
+			return nil, err
+		}
+		r.logger.Printf("Removed %d lock file(s) from %s", len(removed), cfg.avdDir)
+	case recovery.ActionRetryAfterAVDRecreate:
+		if err := cfg.recreateAVD(ctx); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown recovery action: %s", action)
+	}
+	return args, nil
+}
+
+// withGPUMode returns a copy of args with the -gpu option set to mode.
+func withGPUMode(args []string, mode string) []string {
+	newArgs := append([]string{}, args...)
+	for i, arg := range newArgs {
+		if arg == "-gpu" && i+1 < len(newArgs) {
+			newArgs[i+1] = mode
+			return newArgs
+		}
+	}
+	return append(newArgs, "-gpu", mode)
+}
+
+func (r Runner) sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-r.clock.After(d):
+		return nil
+	}
+}
+
+func (r Runner) printRecoveryHistory(history *recovery.History) {
+	if len(history.Records) == 0 {
+		return
+	}
+
+	r.logger.Printf("Boot recovery log:")
+	for _, record := range history.Records {
+		r.logger.Printf("- %s", record)
+	}
+}
+
+// startEmulator runs a single boot attempt.
+func (r Runner) startEmulator(ctx context.Context, adbClient adb.ADB, cfg bootConfig, runningDevices adb.Devices) bootAttempt {
+	faultBuf := &syncBuffer{}
+	var writer io.Writer = faultBuf
+
+	if cfg.logPath != "" {
+		f, err := r.fs.Create(cfg.logPath)
+		if err != nil {
+			r.logger.Warnf("Failed to create emulator log file %s: %s", cfg.logPath, err)
+		} else {
+			defer func() {
+				if err := f.Close(); err != nil {
+					r.logger.Warnf("Failed to close emulator log file: %s", err)
+				}
+			}()
+			writer = io.MultiWriter(f, faultBuf)
+		}
+	}
+
+	// The emulator has to outlive the step, so it isn't bound to the step context: it is killed explicitly,
+	// together with its qemu child process, when the attempt fails or the step is interrupted.
+	emulatorCtx, cancelEmulator := context.WithCancel(context.WithoutCancel(ctx))
+	killEmulator := func() {
+		cancelEmulator()
+	}
+	deviceStartCmd := r.cmdFactory.CreateWithContext(emulatorCtx, cfg.emulatorPath, cfg.args, &command.Opts{Stdout: writer, Stderr: writer})
+
+	r.logger.Infof(startDevicePhase)
+	r.logger.Donef("$ %s", deviceStartCmd.PrintableCommandArgs())
+
+	// The emulator command won't exit after the boot completes, so we start the command and not wait for its result.
+	// Instead, we have a loop with 4 channels:
+	// 1. One that waits for the emulator process to exit
+	// 2. A boot timeout timer
+	// 3. A timer that periodically checks if the device has become online
+	// 4. The step context, cancelled on SIGINT/SIGTERM or when the step deadline is reached
+	if err := deviceStartCmd.Start(); err != nil {
+		killEmulator()
+		return bootAttempt{outcome: bootOutcomeFailed, err: fmt.Errorf("failed to run device start command: %v", err)}
+	}
+
+	emulatorWaitCh := make(chan error, 1)
+	go func() {
+		emulatorWaitCh <- deviceStartCmd.Wait()
+	}()
+
+	timeoutCh := r.clock.After(cfg.timeout)
+
+	printLogHint := func() {
+		r.logger.Printf("Emulator log tail:\n%s", tailLines(faultBuf.String(), 50))
+		if cfg.logPath != "" {
+			r.logger.Printf("Full emulator log: %s", cfg.logPath)
+		}
+	}
+
+	for {
+		select {
+		case err := <-emulatorWaitCh:
+			killEmulator()
+			r.logger.Warnf("Emulator process exited early")
+			if err != nil {
+				r.logger.Errorf("Emulator exit reason: %v", err)
+			} else {
+				r.logger.Warnf("A possible cause can be the emulator process having received a KILL signal.")
+			}
+			printLogHint()
+			return bootAttempt{outcome: bootOutcomeExitedEarly, emulatorLog: faultBuf.String(), err: fmt.Errorf("emulator exited early, see logs above")}
+		case <-timeoutCh:
+			r.logger.Errorf("Failed to boot emulator device within %d seconds.", cfg.timeout/time.Second)
+			printLogHint()
+			killEmulator()
+			return bootAttempt{outcome: bootOutcomeTimedOut, emulatorLog: faultBuf.String(), err: PhaseTimeoutError{Phase: startDevicePhase, Timeout: cfg.timeout}}
+		case <-ctx.Done():
+			r.logger.Warnf("Step interrupted, killing emulator process")
+			killEmulator()
+			return bootAttempt{outcome: bootOutcomeInterrupted, err: InterruptedError{Phase: startDevicePhase, Cause: context.Cause(ctx)}}
+		case <-r.clock.After(cfg.checkInterval):
+			serial, err := adbClient.FindNewDevice(runningDevices)
+			if err != nil {
+				killEmulator()
+				return bootAttempt{outcome: bootOutcomeFailed, err: fmt.Errorf("finding new device: %s", err)}
+			} else if serial != "" {
+				return bootAttempt{outcome: bootOutcomeBooted, serial: serial}
+			}
+			if containsAny(faultBuf.String(), recovery.KernelFaultIndicators) {
+				r.logger.Warnf("Emulator log contains fault")
+				printLogHint()
+				killEmulator()
+				return bootAttempt{outcome: bootOutcomeFault, emulatorLog: faultBuf.String(), err: fmt.Errorf("emulator log contains fault")}
+			}
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, the emulator output is written by the command
+// while the boot loop reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func tailLines(s string, n int) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func containsAny(output string, any []string) bool {
+	for _, fault := range any {
+		if strings.Contains(output, fault) {
+			return true
+		}
+	}
+
+	return false
+}
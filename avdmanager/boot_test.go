@@ -0,0 +1,234 @@
+package avdmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+	"github.com/bitrise-steplib/steps-avd-manager/recovery"
+	"github.com/bitrise-steplib/steps-avd-manager/test"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	noDevicesOutput     = "List of devices attached"
+	newDeviceOutput     = "List of devices attached\nemulator-5556 device product:sdk_gphone64_x86_64 transport_id:2"
+	offlineOutput       = "List of devices attached\nemulator-5556 offline transport_id:2"
+	runningDeviceOutput = "List of devices attached\nemulator-5554 device transport_id:1"
+)
+
+func testBootConfig() bootConfig {
+	return bootConfig{
+		emulatorPath:  "/fake/android/home/emulator/emulator",
+		args:          []string{"@emulator", "-gpu", "auto"},
+		timeout:       time.Second,
+		checkInterval: 5 * time.Millisecond,
+		maxAttempts:   1,
+		policy:        recovery.DefaultPolicy(),
+	}
+}
+
+func newBootTestRunner(cmdFactory *test.ScriptedCommandFactory) (Runner, adb.ADB) {
+	return newTestRunner(cmdFactory, test.NewFakeOutputExporter()), adb.New("/fake/android/home", cmdFactory, log.NewLogger())
+}
+
+// requireEmulatorKilled checks whether the emulator process was killed, killing is observed asynchronously.
+func requireEmulatorKilled(t *testing.T, cmdFactory *test.ScriptedCommandFactory, killed bool) {
+	t.Helper()
+
+	check := func() bool {
+		for _, invocation := range cmdFactory.Invocations() {
+			if invocation.String() != "emulator @emulator -gpu auto" {
+				continue
+			}
+			return invocation.Killed == killed
+		}
+		return false
+	}
+	if killed {
+		require.Eventually(t, check, time.Second, time.Millisecond)
+	} else {
+		require.True(t, check())
+	}
+}
+
+func TestStartEmulator(t *testing.T) {
+	tests := []struct {
+		name           string
+		emulator       test.Response
+		devices        []test.Response
+		runningDevices adb.Devices
+		timeout        time.Duration
+		wantOutcome    bootOutcome
+		wantSerial     string
+		wantKilled     bool
+		wantErr        error
+	}{
+		{
+			name:     "device comes online",
+			emulator: test.Response{UntilKilled: true},
+			devices: []test.Response{
+				{Stdout: noDevicesOutput},
+				{Stdout: offlineOutput},
+				{Stdout: newDeviceOutput},
+			},
+			wantOutcome: bootOutcomeBooted,
+			wantSerial:  "emulator-5556",
+		},
+		{
+			name:     "already running device is ignored",
+			emulator: test.Response{UntilKilled: true},
+			devices: []test.Response{
+				{Stdout: runningDeviceOutput},
+				{Stdout: runningDeviceOutput + "\nemulator-5556 device transport_id:2"},
+			},
+			runningDevices: adb.Devices{"emulator-5554": "device"},
+			wantOutcome:    bootOutcomeBooted,
+			wantSerial:     "emulator-5556",
+		},
+		{
+			name:        "fault in emulator log",
+			emulator:    test.Response{Stdout: "INFO: booting\n[  1.234] Kernel panic - not syncing: VFS\n", UntilKilled: true},
+			devices:     []test.Response{{Stdout: noDevicesOutput}},
+			wantOutcome: bootOutcomeFault,
+			wantKilled:  true,
+		},
+		{
+			name:        "emulator exits early",
+			emulator:    test.Response{Stderr: "FATAL | Running multiple emulators with the same AVD", ExitCode: 1},
+			devices:     []test.Response{{Stdout: noDevicesOutput}},
+			wantOutcome: bootOutcomeExitedEarly,
+		},
+		{
+			name:        "boot times out",
+			emulator:    test.Response{UntilKilled: true},
+			devices:     []test.Response{{Stdout: offlineOutput}},
+			timeout:     50 * time.Millisecond,
+			wantOutcome: bootOutcomeTimedOut,
+			wantKilled:  true,
+			wantErr:     PhaseTimeoutError{Phase: startDevicePhase, Timeout: 50 * time.Millisecond},
+		},
+		{
+			name:        "adb fails",
+			emulator:    test.Response{UntilKilled: true},
+			devices:     []test.Response{{Stderr: "adb: cannot connect to daemon", ExitCode: 1}},
+			wantOutcome: bootOutcomeFailed,
+			wantKilled:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdFactory := test.NewScriptedCommandFactory().
+				On(`^emulator `, tt.emulator).
+				On(`^adb devices -l$`, tt.devices...)
+			runner, adbClient := newBootTestRunner(cmdFactory)
+
+			cfg := testBootConfig()
+			if tt.timeout != 0 {
+				cfg.timeout = tt.timeout
+			}
+			runningDevices := tt.runningDevices
+			if runningDevices == nil {
+				runningDevices = adb.Devices{}
+			}
+
+			result := runner.startEmulator(context.Background(), adbClient, cfg, runningDevices)
+
+			require.Equal(t, tt.wantOutcome, result.outcome)
+			require.Equal(t, tt.wantSerial, result.serial)
+			if tt.wantErr != nil {
+				require.Equal(t, tt.wantErr, result.err)
+			}
+			if tt.wantOutcome == bootOutcomeBooted {
+				require.Equal(t, len(tt.devices), cmdFactory.Count(`^adb devices -l$`))
+			}
+			if tt.emulator.UntilKilled {
+				requireEmulatorKilled(t, cmdFactory, tt.wantKilled)
+			}
+			require.Empty(t, cmdFactory.Unmatched())
+		})
+	}
+}
+
+func TestStartEmulator_Interrupted(t *testing.T) {
+	cmdFactory := test.NewScriptedCommandFactory().
+		On(`^emulator `, test.Response{UntilKilled: true}).
+		On(`^adb devices -l$`, test.Response{Stdout: noDevicesOutput})
+	runner, adbClient := newBootTestRunner(cmdFactory)
+
+	cause := errors.New("received SIGTERM")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(20*time.Millisecond, func() { cancel(cause) })
+
+	result := runner.startEmulator(ctx, adbClient, testBootConfig(), adb.Devices{})
+
+	require.Equal(t, bootOutcomeInterrupted, result.outcome)
+	require.ErrorIs(t, result.err, cause)
+	requireEmulatorKilled(t, cmdFactory, true)
+}
+
+func TestBootEmulator_Recovery(t *testing.T) {
+	cmdFactory := test.NewScriptedCommandFactory().
+		On(`^emulator `,
+			test.Response{ExitCode: 1},
+			test.Response{Duration: 10 * time.Millisecond, ExitCode: 1},
+			test.Response{UntilKilled: true},
+		).
+		On(`^adb kill-server$`).
+		On(`^adb devices -l$`,
+			test.Response{Stdout: noDevicesOutput},
+			test.Response{Stdout: noDevicesOutput},
+			test.Response{Stdout: newDeviceOutput},
+		)
+	runner, adbClient := newBootTestRunner(cmdFactory)
+
+	cfg := testBootConfig()
+	cfg.maxAttempts = 3
+	cfg.policy = recovery.NewPolicy(map[recovery.Failure][]recovery.Action{
+		recovery.FailureExitedEarly: {recovery.ActionRetryAfterADBRestart, recovery.ActionRetryWithSoftwareGPU},
+	}, time.Millisecond, time.Millisecond)
+
+	serial, err := runner.bootEmulator(context.Background(), adbClient, cfg, adb.Devices{})
+
+	require.NoError(t, err)
+	require.Equal(t, "emulator-5556", serial)
+	require.Equal(t, []string{
+		"emulator @emulator -gpu auto",
+		"adb kill-server",
+		"emulator @emulator -gpu auto",
+		"emulator @emulator -gpu swiftshader_indirect",
+	}, filterCommands(cmdFactory.Commands(), "adb devices -l"))
+}
+
+func TestBootEmulator_AttemptsExhausted(t *testing.T) {
+	cmdFactory := test.NewScriptedCommandFactory().
+		On(`^emulator `, test.Response{ExitCode: 1}).
+		On(`^adb devices -l$`, test.Response{Stdout: noDevicesOutput})
+	runner, adbClient := newBootTestRunner(cmdFactory)
+
+	cfg := testBootConfig()
+	cfg.maxAttempts = 2
+	cfg.policy = recovery.NewPolicy(map[recovery.Failure][]recovery.Action{
+		recovery.FailureExitedEarly: {recovery.ActionRetry},
+	}, time.Millisecond, time.Millisecond)
+
+	_, err := runner.bootEmulator(context.Background(), adbClient, cfg, adb.Devices{})
+
+	var bootErr BootError
+	require.ErrorAs(t, err, &bootErr)
+	require.Equal(t, 2, bootErr.Attempts)
+	require.Equal(t, 2, cmdFactory.Count(`^emulator `))
+}
+
+func filterCommands(commands []string, exclude string) []string {
+	var filtered []string
+	for _, command := range commands {
+		if command != exclude {
+			filtered = append(filtered, command)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,115 @@
+package avdmanager
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+	"github.com/bitrise-steplib/steps-avd-manager/cacerts"
+)
+
+// maxSystemStoreAPILevel is the last API level with a remountable system trust store.
+const maxSystemStoreAPILevel = 33
+
+var errRebootRequired = errors.New("reboot required to remount the system partition")
+
+func validateCACertificates(cfg Config) error {
+	if len(nonEmptyLines(cfg.CACertificates)) == 0 {
+		return nil
+	}
+
+	if cfg.RemoteEmulatorAddress != "" {
+		return fmt.Errorf("ca_certificates require an emulator started with -writable-system, they can't be used with remote_emulator_address")
+	}
+	if strings.Contains(cfg.Tag, "playstore") {
+		return fmt.Errorf("ca_certificates can't be installed on %s images: Play Store images are production builds without adb root, use a google_apis image instead", cfg.Tag)
+	}
+	if apiLevel, err := strconv.Atoi(cfg.APILevel); err == nil && apiLevel > maxSystemStoreAPILevel {
+		return fmt.Errorf("ca_certificates can't be installed on API %d: from API 34 the system trust store is part of the read-only Conscrypt module, use API %d or lower", apiLevel, maxSystemStoreAPILevel)
+	}
+	return nil
+}
+
+func nonEmptyLines(lines []string) []string {
+	var nonEmpty []string
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			nonEmpty = append(nonEmpty, line)
+		}
+	}
+	return nonEmpty
+}
+
+// installCACertificates adds the certificates to the system trust store. waitForBoot is called when remounting
+// the system partition requires a reboot.
+func (r Runner) installCACertificates(adbClient adb.ADB, serial string, certs []cacerts.Certificate, waitForBoot func() error) error {
+	r.logger.Println()
+	r.logger.Infof("Installing %d CA certificate(s)", len(certs))
+
+	if err := r.remountSystem(adbClient, serial); err != nil {
+		if !errors.Is(err, errRebootRequired) {
+			return err
+		}
+
+		r.logger.Printf("Rebooting to disable verity")
+		if err := adbClient.Reboot(serial); err != nil {
+			return err
+		}
+		if err := waitForBoot(); err != nil {
+			return err
+		}
+		if err := r.remountSystem(adbClient, serial); err != nil {
+			return err
+		}
+	}
+
+	tmpDir, err := r.fs.MkdirTemp("", "cacerts")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.fs.RemoveAll(tmpDir); err != nil {
+			r.logger.Warnf("Failed to remove %s: %s", tmpDir, err)
+		}
+	}()
+
+	for _, cert := range certs {
+		r.logger.Printf("%s: %s (%s)", cert.Path, cert.Cert.Subject, cert.FileName)
+
+		local := filepath.Join(tmpDir, cert.FileName)
+		if err := r.fs.WriteFile(local, cert.PEM(), 0644); err != nil {
+			return err
+		}
+
+		remote := path.Join(cacerts.SystemStoreDir, cert.FileName)
+		if err := adbClient.PushVerified(serial, local, remote); err != nil {
+			return err
+		}
+		if _, err := adbClient.Shell(serial, "chmod", "644", remote); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r Runner) remountSystem(adbClient adb.ADB, serial string) error {
+	if err := adbClient.Root(serial); err != nil {
+		return fmt.Errorf("installing CA certificates requires adb root, which isn't available on this image: %w", err)
+	}
+	if err := adbClient.WaitForOnline(serial); err != nil {
+		return err
+	}
+
+	rebootRequired, err := adbClient.Remount(serial)
+	if err != nil {
+		return fmt.Errorf("failed to remount the system partition, was the emulator started with -writable-system? %w", err)
+	}
+	if rebootRequired {
+		return errRebootRequired
+	}
+	return nil
+}
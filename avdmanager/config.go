@@ -0,0 +1,150 @@
+package avdmanager
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Config is the step configuration, parsed from the step inputs.
+type Config struct {
+	AndroidHome               string   `env:"ANDROID_HOME"`
+	DeployDir                 string   `env:"BITRISE_DEPLOY_DIR"`
+	APILevel                  string   `env:"api_level,required"`
+	Tag                       string   `env:"tag,opt[google_apis,google_apis_ps16k,google_apis_playstore,google_apis_playstore_ps16k,aosp_atd,google_atd,android-wear,android-tv,default]"`
+	DeviceProfile             string   `env:"profile,required"`
+	DisableAnimations         bool     `env:"disable_animations,opt[yes,no]"`
+	CreateCommandArgs         string   `env:"create_command_flags"`
+	SDCardSize                string   `env:"sdcard_size"`
+	AVDCreator                string   `env:"avd_creator,opt[avdmanager,native]"`
+	ReuseAVD                  string   `env:"reuse_avd,opt[recreate,reuse-if-compatible,fail-if-exists]"`
+	StartCommandArgs          string   `env:"start_command_flags"`
+	ID                        string   `env:"emulator_id,required"`
+	Abi                       string   `env:"abi,opt[x86,armeabi-v7a,arm64-v8a,x86_64]"`
+	EmulatorChannel           string   `env:"emulator_channel,opt[no update,0,1,2,3]"`
+	EmulatorBuildNumber       string   `env:"emulator_build_number,required"`
+	EmulatorDownloadBaseURL   string   `env:"emulator_download_base_url"`
+	SystemImageInstaller      string   `env:"system_image_installer,opt[sdkmanager,native]"`
+	IsHeadlessMode            bool     `env:"headless_mode,opt[yes,no]"`
+	HostDebugTags             string   `env:"host_debug_tags"`
+	DeviceLogcatTags          string   `env:"device_logcat_tags"`
+	EmulatorUpdateTimeout     int      `env:"emulator_update_timeout,required"`
+	SystemImageInstallTimeout int      `env:"system_image_install_timeout,required"`
+	CreateAVDTimeout          int      `env:"create_avd_timeout,required"`
+	BootTimeout               int      `env:"boot_timeout,required"`
+	BootCheckInterval         int      `env:"boot_check_interval,required"`
+	MaxBootAttempts           int      `env:"max_boot_attempts,required"`
+	StepTimeout               int      `env:"step_timeout,required"`
+	KillStaleEmulators        bool     `env:"kill_stale_emulators,opt[yes,no]"`
+	HostCheck                 bool     `env:"host_check,opt[yes,no]"`
+	RemoteEmulatorAddress     string   `env:"remote_emulator_address"`
+	DeviceSettingsPreset      string   `env:"device_settings_preset,opt[none,ui-testing]"`
+	DeviceSettings            string   `env:"device_settings"`
+	Locale                    string   `env:"locale"`
+	Timezone                  string   `env:"timezone"`
+	FixedTime                 string   `env:"fixed_time"`
+	APKsToInstall             []string `env:"apks_to_install,multiline"`
+	GrantPermissions          bool     `env:"apk_install_grant_permissions,opt[yes,no]"`
+	AllowTestOnlyAPKs         bool     `env:"apk_install_allow_test_only,opt[yes,no]"`
+	FilesToPush               []string `env:"files_to_push,multiline"`
+	HTTPProxy                 string   `env:"http_proxy"`
+	DNSServers                string   `env:"dns_servers"`
+	NetworkSpeed              string   `env:"network_speed,required"`
+	NetworkDelay              string   `env:"network_delay,required"`
+	PortRules                 []string `env:"port_rules,multiline"`
+	CACertificates            []string `env:"ca_certificates,multiline"`
+}
+
+const (
+	emuChannelNoUpdate         = "no update"
+	emuBuildNumberPreinstalled = "preinstalled"
+	systemImageInstallerNative = "native"
+	avdCreatorNative           = "native"
+	reuseAVDIfCompatible       = "reuse-if-compatible"
+	reuseAVDFailIfExists       = "fail-if-exists"
+	hostLogSuffix              = "_host.log"
+	deviceLogcatSuffix         = "_device_logcat.log"
+	staleProcessGracePeriod    = 10 * time.Second
+)
+
+// Rough sizes for the host check: an extracted system image plus its download, and the AVD userdata plus SD card.
+const (
+	estimatedSystemImageBytes = 6 << 30
+	estimatedAVDBytes         = 8 << 30
+	defaultRAMMegabytes       = 2048
+)
+
+var sdCardSizePattern = regexp.MustCompile(`^[0-9]+[KMG]?$`)
+
+func secondsToDuration(seconds int) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.EmulatorChannel != emuChannelNoUpdate && cfg.EmulatorBuildNumber != emuBuildNumberPreinstalled {
+		return fmt.Errorf("emulator_channel is set to `%s`, and emulator_build_number is also set to `%s`. These inputs are exclusive, please set either of them to the default value", cfg.EmulatorChannel, cfg.EmulatorBuildNumber)
+	}
+	if cfg.EmulatorDownloadBaseURL != "" {
+		u, err := url.Parse(cfg.EmulatorDownloadBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("emulator_download_base_url must be an http(s) URL, got %s", cfg.EmulatorDownloadBaseURL)
+		}
+	}
+
+	if cfg.SDCardSize != "" && !sdCardSizePattern.MatchString(cfg.SDCardSize) {
+		return fmt.Errorf("sdcard_size must be a size like 2048M, 512K or 2G, got %s", cfg.SDCardSize)
+	}
+	if cfg.AVDCreator == avdCreatorNative && cfg.CreateCommandArgs != "" {
+		return fmt.Errorf("create_command_flags are avdmanager flags, they can't be used with avd_creator: native")
+	}
+
+	timeouts := map[string]int{
+		"emulator_update_timeout":      cfg.EmulatorUpdateTimeout,
+		"system_image_install_timeout": cfg.SystemImageInstallTimeout,
+		"create_avd_timeout":           cfg.CreateAVDTimeout,
+	}
+	for input, value := range timeouts {
+		if value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", input, value)
+		}
+	}
+
+	if cfg.BootTimeout <= 0 {
+		return fmt.Errorf("boot_timeout must be positive, got %d", cfg.BootTimeout)
+	}
+	if cfg.BootCheckInterval <= 0 {
+		return fmt.Errorf("boot_check_interval must be positive, got %d", cfg.BootCheckInterval)
+	}
+	if cfg.BootCheckInterval >= cfg.BootTimeout {
+		return fmt.Errorf("boot_check_interval (%d) must be less than boot_timeout (%d)", cfg.BootCheckInterval, cfg.BootTimeout)
+	}
+	if cfg.MaxBootAttempts < 1 {
+		return fmt.Errorf("max_boot_attempts must be at least 1, got %d", cfg.MaxBootAttempts)
+	}
+	if cfg.StepTimeout < 0 {
+		return fmt.Errorf("step_timeout must not be negative, got %d", cfg.StepTimeout)
+	}
+	if cfg.StepTimeout > 0 && cfg.StepTimeout < cfg.BootTimeout {
+		return fmt.Errorf("step_timeout (%d) must not be less than boot_timeout (%d)", cfg.StepTimeout, cfg.BootTimeout)
+	}
+
+	if cfg.RemoteEmulatorAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.RemoteEmulatorAddress); err != nil {
+			return fmt.Errorf("remote_emulator_address must be in host:port format: %s", err)
+		}
+	}
+
+	if err := validateLocalization(cfg); err != nil {
+		return err
+	}
+	if err := validateNetwork(cfg); err != nil {
+		return err
+	}
+	if err := validateCACertificates(cfg); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package avdmanager
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// InputError is returned when the step inputs are invalid, before anything is installed or started.
+type InputError struct {
+	Err error
+}
+
+func (e InputError) Error() string {
+	return fmt.Sprintf("step input validation failed: %s", e.Err)
+}
+
+func (e InputError) Unwrap() error {
+	return e.Err
+}
+
+// FlagConflictError is returned when start_command_flags sets flags that are controlled by a dedicated input.
+type FlagConflictError struct {
+	Flags []string
+	Input string
+}
+
+func (e FlagConflictError) Error() string {
+	return fmt.Sprintf("conflicting flags: %s is already set in start_command_flags, use the %s input instead", strings.Join(e.Flags, "/"), e.Input)
+}
+
+// PhaseError is returned when the command of a phase fails.
+type PhaseError struct {
+	Phase  string
+	Output string
+	Err    error
+}
+
+func (e PhaseError) Error() string {
+	return fmt.Sprintf("failed to run phase %q: %s, output: %s", e.Phase, e.Err, e.Output)
+}
+
+func (e PhaseError) Unwrap() error {
+	return e.Err
+}
+
+// PhaseTimeoutError is returned when a phase doesn't finish within its timeout.
+type PhaseTimeoutError struct {
+	Phase   string
+	Timeout time.Duration
+}
+
+func (e PhaseTimeoutError) Error() string {
+	return fmt.Sprintf("phase %q timed out after %s", e.Phase, e.Timeout)
+}
+
+// InterruptedError is returned when the step is cancelled by a signal or the step timeout during a phase.
+type InterruptedError struct {
+	Phase string
+	Cause error
+}
+
+func (e InterruptedError) Error() string {
+	return fmt.Sprintf("phase %q interrupted: %s", e.Phase, e.Cause)
+}
+
+func (e InterruptedError) Unwrap() error {
+	return e.Cause
+}
+
+// BootError is returned when the emulator doesn't boot within the attempt budget.
+type BootError struct {
+	Attempts int
+	Err      error
+}
+
+func (e BootError) Error() string {
+	return fmt.Sprintf("failed to boot device after %d attempts: %s", e.Attempts, e.Err)
+}
+
+func (e BootError) Unwrap() error {
+	return e.Err
+}
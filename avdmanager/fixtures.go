@@ -0,0 +1,75 @@
+package avdmanager
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+)
+
+// fileTransfer is a local file and its destination on the device.
+type fileTransfer struct {
+	local  string
+	remote string
+}
+
+// resolveFileTransfers expands the files_to_push lines (local:remote) into single file transfers.
+// A local directory is pushed recursively, keeping its layout under the remote directory.
+func resolveFileTransfers(mappings []string) ([]fileTransfer, error) {
+	var transfers []fileTransfer
+	for _, mapping := range mappings {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+
+		local, remote, found := strings.Cut(mapping, ":")
+		local, remote = strings.TrimSpace(local), strings.TrimSpace(remote)
+		if !found || local == "" || remote == "" {
+			return nil, fmt.Errorf("invalid mapping %s, expected local_path:device_path", mapping)
+		}
+		if !path.IsAbs(remote) {
+			return nil, fmt.Errorf("device path must be absolute, got %s", remote)
+		}
+
+		info, err := os.Stat(local)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			transfers = append(transfers, fileTransfer{local: local, remote: remote})
+			continue
+		}
+
+		if err := filepath.WalkDir(local, func(localPath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(local, localPath)
+			if err != nil {
+				return err
+			}
+			transfers = append(transfers, fileTransfer{local: localPath, remote: path.Join(remote, filepath.ToSlash(rel))})
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return transfers, nil
+}
+
+// pushFiles pushes the files to the device and verifies their sizes.
+func (r Runner) pushFiles(adbClient adb.ADB, serial string, transfers []fileTransfer) error {
+	r.logger.Println()
+	r.logger.Infof("Pushing %d file(s)", len(transfers))
+	for _, transfer := range transfers {
+		if err := adbClient.PushVerified(serial, transfer.local, transfer.remote); err != nil {
+			return fmt.Errorf("failed to push %s to %s: %w", transfer.local, transfer.remote, err)
+		}
+	}
+	return nil
+}
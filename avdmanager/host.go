@@ -0,0 +1,96 @@
+package avdmanager
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-avd-manager/avd"
+	"github.com/bitrise-steplib/steps-avd-manager/devices"
+	"github.com/bitrise-steplib/steps-avd-manager/hostcheck"
+	"github.com/bitrise-steplib/steps-avd-manager/stale"
+)
+
+// checkStaleEmulators reports emulator processes and AVD locks left behind by earlier runs of the same AVD,
+// and removes them when cleanup is enabled.
+func (r Runner) checkStaleEmulators(avdDir, id string, cleanup bool) error {
+	r.logger.Infof("Checking for stale emulator processes")
+
+	report, err := stale.Scan(r.procRoot, avdDir, id)
+	if err != nil {
+		return err
+	}
+	if report.Empty() {
+		r.logger.Donef("No stale emulator processes or AVD locks found")
+		r.logger.Println()
+		return nil
+	}
+
+	for _, process := range report.Processes {
+		r.logger.Warnf("Stale emulator process: %s", process)
+	}
+	for _, lock := range report.LockFiles {
+		r.logger.Warnf("Stale AVD lock: %s", lock)
+	}
+
+	if !cleanup {
+		r.logger.Warnf("Set kill_stale_emulators to `yes` to terminate these processes and remove the locks before boot.")
+		r.logger.Println()
+		return nil
+	}
+
+	if err := stale.Terminate(r.procRoot, report.Processes, staleProcessGracePeriod); err != nil {
+		return err
+	}
+	if _, err := avd.RemoveLockFiles(avdDir); err != nil {
+		return err
+	}
+	r.logger.Donef("Terminated %d process(es) and removed %d lock(s)", len(report.Processes), len(report.LockFiles))
+	r.logger.Println()
+
+	return nil
+}
+
+// checkHost prints the host capability report and fails on hard blockers, before any time is spent on downloads.
+func (r Runner) checkHost(hostCfg hostcheck.Config) error {
+	r.logger.Infof("Checking host capabilities")
+
+	report := hostcheck.NewChecker(r.cmdFactory).Run(hostCfg)
+	report.Print(r.logger)
+	r.logger.Println()
+
+	if failed := report.Failed(); len(failed) > 0 {
+		var names []string
+		for _, result := range failed {
+			names = append(names, result.Name)
+		}
+		return fmt.Errorf("the host can't run the emulator, failed checks: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// ramMegabytes returns the guest RAM size: the -memory start flag, or the hw.ramSize of the AVD if it's going to be
+// reused, or the RAM size of the device profile. The default is only used when none of these is known.
+func (r Runner) ramMegabytes(cfg Config, startFlags []string, device devices.Device, avdDir string) uint64 {
+	for i, flag := range startFlags {
+		if flag == "-memory" && i+1 < len(startFlags) {
+			if megabytes, err := strconv.ParseUint(startFlags[i+1], 10, 64); err == nil {
+				return megabytes
+			}
+		}
+	}
+
+	if cfg.ReuseAVD == reuseAVDIfCompatible {
+		if megabytes, err := avd.RAMMegabytes(avdDir); err == nil && megabytes > 0 {
+			return uint64(megabytes)
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			r.logger.Warnf("Failed to read the RAM size of the existing AVD: %s", err)
+		}
+	}
+	if device.RAMMegabytes > 0 {
+		return uint64(device.RAMMegabytes)
+	}
+	return defaultRAMMegabytes
+}
@@ -0,0 +1,142 @@
+package avdmanager
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+)
+
+// fixedTimeTolerance is the accepted difference between the requested and the device time when verifying,
+// it covers the time spent between setting and reading back the date.
+const fixedTimeTolerance = time.Minute
+
+var (
+	localePattern   = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	timezonePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+\-]*(/[A-Za-z0-9_+\-]+)*$`)
+)
+
+func validateLocalization(cfg Config) error {
+	if cfg.Locale != "" {
+		if !localePattern.MatchString(cfg.Locale) {
+			return fmt.Errorf("locale must be a BCP 47 language tag (e.g. en-US), got %s", cfg.Locale)
+		}
+		if cfg.RemoteEmulatorAddress != "" {
+			return fmt.Errorf("locale is applied with the emulator -change-locale flag, it can't be used with remote_emulator_address")
+		}
+	}
+	if cfg.Timezone != "" && !timezonePattern.MatchString(cfg.Timezone) {
+		return fmt.Errorf("timezone must be an Olson timezone ID (e.g. Europe/Budapest), got %s", cfg.Timezone)
+	}
+	if cfg.FixedTime != "" {
+		if _, err := time.Parse(time.RFC3339, cfg.FixedTime); err != nil {
+			return fmt.Errorf("fixed_time must be an RFC 3339 timestamp (e.g. 2024-01-31T09:00:00Z): %s", err)
+		}
+	}
+	return nil
+}
+
+func localizationEnabled(cfg Config) bool {
+	return cfg.Locale != "" || cfg.Timezone != "" || cfg.FixedTime != ""
+}
+
+// applyLocalization sets the timezone and the date of the booted device, then verifies them together with the
+// locale, which is set at emulator start.
+func (r Runner) applyLocalization(adbClient adb.ADB, serial string, cfg Config) error {
+	if cfg.Timezone != "" {
+		r.logger.Printf("Setting timezone to %s", cfg.Timezone)
+		if err := r.setTimezone(adbClient, serial, cfg.Timezone); err != nil {
+			return err
+		}
+	}
+
+	if cfg.FixedTime != "" {
+		fixedTime, err := time.Parse(time.RFC3339, cfg.FixedTime)
+		if err != nil {
+			return err
+		}
+		r.logger.Printf("Setting date to %s", fixedTime.UTC().Format(time.RFC3339))
+		if err := r.setTime(adbClient, serial, fixedTime); err != nil {
+			return err
+		}
+	}
+
+	return r.verifyLocalization(adbClient, serial, cfg)
+}
+
+func (r Runner) setTimezone(adbClient adb.ADB, serial, timezone string) error {
+	// Automatic timezone detection would override the configured one.
+	if _, err := adbClient.Shell(serial, "settings", "put", "global", "auto_time_zone", "0"); err != nil {
+		return err
+	}
+
+	// The alarm manager updates persist.sys.timezone and notifies running apps, but it is only available from API 28.
+	// Setting the property directly is the fallback for older images.
+	if _, err := adbClient.Shell(serial, "cmd", "alarm", "set-timezone", timezone); err != nil {
+		r.logger.Warnf("cmd alarm set-timezone failed, falling back to setprop: %s", err)
+		if _, err := adbClient.Shell(serial, "setprop", "persist.sys.timezone", timezone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r Runner) setTime(adbClient adb.ADB, serial string, fixedTime time.Time) error {
+	// Automatic time (NITZ/NTP) would override the configured date.
+	if _, err := adbClient.Shell(serial, "settings", "put", "global", "auto_time", "0"); err != nil {
+		return err
+	}
+
+	// Setting the date requires root.
+	if err := adbClient.Root(serial); err != nil {
+		return fmt.Errorf("setting the date requires adb root, which isn't available on this image: %w", err)
+	}
+	if err := adbClient.WaitForOnline(serial); err != nil {
+		return err
+	}
+
+	// toybox date: MMDDhhmm[[CC]YY][.ss]
+	if _, err := adbClient.Shell(serial, "date", "-u", fixedTime.UTC().Format("010215042006.05")); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r Runner) verifyLocalization(adbClient adb.ADB, serial string, cfg Config) error {
+	properties, err := adbClient.Properties(serial)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Locale != "" && !strings.EqualFold(properties.Locale(), cfg.Locale) {
+		return fmt.Errorf("locale didn't stick: expected %s, device has %s", cfg.Locale, properties.Locale())
+	}
+	if cfg.Timezone != "" && properties.Timezone() != cfg.Timezone {
+		return fmt.Errorf("timezone didn't stick: expected %s, device has %s", cfg.Timezone, properties.Timezone())
+	}
+
+	if cfg.FixedTime != "" {
+		fixedTime, err := time.Parse(time.RFC3339, cfg.FixedTime)
+		if err != nil {
+			return err
+		}
+		out, err := adbClient.Shell(serial, "date", "+%s")
+		if err != nil {
+			return err
+		}
+		seconds, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse device date (%s): %w", out, err)
+		}
+		deviceTime := time.Unix(seconds, 0)
+		if diff := deviceTime.Sub(fixedTime); diff < 0 || diff > fixedTimeTolerance {
+			return fmt.Errorf("date didn't stick: expected %s, device has %s", fixedTime.UTC().Format(time.RFC3339), deviceTime.UTC().Format(time.RFC3339))
+		}
+	}
+
+	r.logger.Donef("Locale: %s, timezone: %s", properties.Locale(), properties.Timezone())
+	return nil
+}
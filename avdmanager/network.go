@@ -0,0 +1,153 @@
+package avdmanager
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-avd-manager/deviceprep"
+)
+
+const (
+	networkSpeedFull = "full"
+	networkDelayNone = "none"
+	// maxDNSServers is the number of DNS servers the emulator accepts with -dns-server.
+	maxDNSServers = 4
+)
+
+var (
+	// Values accepted by the emulator -netspeed and -netdelay flags: a named profile, a single value (kbps or ms),
+	// or an <up>:<down> (kbps) or <min>:<max> (ms) pair.
+	networkSpeedProfiles = []string{"gsm", "hscsd", "gprs", "edge", "umts", "hsdpa", "lte", "evdo", networkSpeedFull}
+	networkDelayProfiles = []string{"gsm", "hscsd", "gprs", "edge", "umts", "hsdpa", "lte", "evdo", networkDelayNone}
+	networkValuePattern  = regexp.MustCompile(`^[0-9]+(:[0-9]+)?$`)
+)
+
+func validateNetwork(cfg Config) error {
+	if err := validateNetworkValue(cfg.NetworkSpeed, networkSpeedProfiles); err != nil {
+		return fmt.Errorf("network_speed: %w", err)
+	}
+	if err := validateNetworkValue(cfg.NetworkDelay, networkDelayProfiles); err != nil {
+		return fmt.Errorf("network_delay: %w", err)
+	}
+
+	// The speed and latency are only applied with emulator flags, and the step doesn't start a remote emulator.
+	if cfg.RemoteEmulatorAddress != "" && (cfg.NetworkSpeed != networkSpeedFull || cfg.NetworkDelay != networkDelayNone) {
+		return fmt.Errorf("network_speed and network_delay are applied with the emulator -netspeed and -netdelay flags, they can't be used with remote_emulator_address")
+	}
+
+	if cfg.HTTPProxy != "" {
+		proxy, err := parseHTTPProxy(cfg.HTTPProxy)
+		if err != nil {
+			return fmt.Errorf("http_proxy: %w", err)
+		}
+		if cfg.RemoteEmulatorAddress != "" && proxy.User != nil {
+			return fmt.Errorf("http_proxy: the proxy of a remote emulator is set with the http_proxy global setting, which doesn't support credentials")
+		}
+	}
+
+	if servers := dnsServers(cfg.DNSServers); len(servers) > 0 {
+		if cfg.RemoteEmulatorAddress != "" {
+			return fmt.Errorf("dns_servers are applied with the emulator -dns-server flag, they can't be used with remote_emulator_address")
+		}
+		if len(servers) > maxDNSServers {
+			return fmt.Errorf("dns_servers: the emulator accepts at most %d servers, got %d", maxDNSServers, len(servers))
+		}
+		for _, server := range servers {
+			if net.ParseIP(server) == nil {
+				return fmt.Errorf("dns_servers: %s is not an IP address", server)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateNetworkValue(value string, profiles []string) error {
+	for _, profile := range profiles {
+		if value == profile {
+			return nil
+		}
+	}
+	if networkValuePattern.MatchString(value) {
+		return nil
+	}
+	return fmt.Errorf("must be one of %s, a number or a <min>:<max> pair, got %s", strings.Join(profiles, ", "), value)
+}
+
+// parseHTTPProxy parses the proxy in one of the formats accepted by the emulator -http-proxy flag:
+// <host>:<port> or http://[<username>:<password>@]<host>:<port>.
+func parseHTTPProxy(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme != "http" {
+		return nil, fmt.Errorf("only http proxies are supported, got %s", proxyURL.Scheme)
+	}
+	if proxyURL.Hostname() == "" || proxyURL.Port() == "" {
+		return nil, fmt.Errorf("proxy must be in <host>:<port> or http://[<username>:<password>@]<host>:<port> format")
+	}
+	if proxyURL.Path != "" && proxyURL.Path != "/" {
+		return nil, fmt.Errorf("proxy must not have a path, got %s", proxyURL.Path)
+	}
+	return proxyURL, nil
+}
+
+func dnsServers(servers string) []string {
+	var list []string
+	for _, server := range strings.Split(servers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			list = append(list, server)
+		}
+	}
+	return list
+}
+
+// networkArgs returns the emulator flags of the network configuration. Flags already set in start_command_flags
+// take precedence.
+func networkArgs(cfg Config, startFlags []string) []string {
+	var args []string
+	addFlag := func(flag, value string) {
+		for _, startFlag := range startFlags {
+			if startFlag == flag {
+				return
+			}
+		}
+		args = append(args, flag, value)
+	}
+
+	addFlag("-netspeed", cfg.NetworkSpeed)
+	addFlag("-netdelay", cfg.NetworkDelay)
+	if cfg.HTTPProxy != "" {
+		addFlag("-http-proxy", cfg.HTTPProxy)
+	}
+	if servers := dnsServers(cfg.DNSServers); len(servers) > 0 {
+		addFlag("-dns-server", strings.Join(servers, ","))
+	}
+	return args
+}
+
+// remoteProxySetting returns the device setting configuring the proxy of a remote emulator,
+// which can't be started with the -http-proxy flag.
+func remoteProxySetting(cfg Config) []deviceprep.Item {
+	if cfg.RemoteEmulatorAddress == "" || cfg.HTTPProxy == "" {
+		return nil
+	}
+
+	// validated by validateNetwork
+	proxy, _ := parseHTTPProxy(cfg.HTTPProxy)
+	return []deviceprep.Item{{
+		Type:      deviceprep.ItemTypeSetting,
+		Namespace: "global",
+		Key:       "http_proxy",
+		Value:     proxy.Host,
+		OnError:   deviceprep.OnErrorFail,
+	}}
+}
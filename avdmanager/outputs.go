@@ -0,0 +1,65 @@
+package avdmanager
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+)
+
+type stepOutput struct {
+	key   string
+	value string
+}
+
+// deviceOutputs logs the properties identifying the booted system image and returns the ones exported as outputs,
+// so that test reports can be correlated with the exact image.
+func (r Runner) deviceOutputs(adbClient adb.ADB, serial string) []stepOutput {
+	properties, err := adbClient.Properties(serial)
+	if err != nil {
+		r.logger.Warnf("Failed to read device properties: %s", err)
+		return nil
+	}
+
+	sdkInt := ""
+	if value, err := properties.SDKInt(); err != nil {
+		r.logger.Warnf("%s", err)
+	} else {
+		sdkInt = strconv.Itoa(value)
+	}
+
+	r.logger.Println()
+	r.logger.Infof("Device properties")
+	r.logger.Printf("SDK level: %s", sdkInt)
+	r.logger.Printf("Android version: %s", properties.Release())
+	r.logger.Printf("ABIs: %s", strings.Join(properties.ABIList(), ", "))
+	r.logger.Printf("Build fingerprint: %s", properties.Fingerprint())
+	r.logger.Printf("Locale: %s", properties.Locale())
+	r.logger.Printf("Timezone: %s", properties.Timezone())
+	r.logger.Printf("GPU renderer: %s", properties.GPURenderer())
+
+	return []stepOutput{
+		{"BITRISE_EMULATOR_FINGERPRINT", properties.Fingerprint()},
+		{"BITRISE_EMULATOR_SDK_INT", sdkInt},
+	}
+}
+
+// exportOutputs exports and prints the outputs with a value.
+func (r Runner) exportOutputs(outputs []stepOutput) {
+	for _, output := range outputs {
+		if output.value == "" {
+			continue
+		}
+		if err := r.exporter.ExportOutput(output.key, output.value); err != nil {
+			r.logger.Warnf("Failed to export %s: %s", output.key, err)
+		}
+	}
+
+	r.logger.Println()
+	r.logger.Infof("Step outputs")
+	for _, output := range outputs {
+		if output.value != "" {
+			r.logger.Printf("$%s = %s", output.key, output.value)
+		}
+	}
+}
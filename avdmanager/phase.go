@@ -0,0 +1,171 @@
+package avdmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// phase runs either a command or, if run is set, a Go function.
+type phase struct {
+	name    string
+	timeout time.Duration
+	cmdName string
+	args    []string
+	stdin   string
+	run     func(ctx context.Context) error
+}
+
+// systemImageInstaller installs a system image package natively, without sdkmanager.
+type systemImageInstaller interface {
+	Install(ctx context.Context, pkgPath string, channel int) error
+}
+
+// runPhase runs the phase command under ctx, and under the phase timeout if one is set.
+// The command and its child processes are killed when either of them expires.
+func (r Runner) runPhase(ctx context.Context, p phase) error {
+	phaseCtx := ctx
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		phaseCtx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	if p.run != nil {
+		r.logger.Infof(p.name)
+
+		startTime := r.clock.Now()
+		err := p.run(phaseCtx)
+		r.logger.Printf("Duration: %s", r.clock.Now().Sub(startTime).Round(time.Millisecond))
+		if err != nil {
+			return classifyPhaseError(ctx, phaseCtx, p, "", err)
+		}
+		return nil
+	}
+
+	var opts *command.Opts
+	if p.stdin != "" {
+		opts = &command.Opts{Stdin: strings.NewReader(p.stdin)}
+	}
+	cmd := r.cmdFactory.CreateWithContext(phaseCtx, p.cmdName, p.args, opts)
+
+	r.logger.Infof(p.name)
+	r.logger.Donef("$ %s", cmd.PrintableCommandArgs())
+
+	startTime := r.clock.Now()
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	r.logger.Printf("Duration: %s", r.clock.Now().Sub(startTime).Round(time.Millisecond))
+	if err != nil {
+		return classifyPhaseError(ctx, phaseCtx, p, out, err)
+	}
+
+	return nil
+}
+
+func classifyPhaseError(ctx, phaseCtx context.Context, p phase, out string, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return InterruptedError{Phase: p.name, Cause: context.Cause(ctx)}
+	case errors.Is(phaseCtx.Err(), context.DeadlineExceeded):
+		return PhaseTimeoutError{Phase: p.name, Timeout: p.timeout}
+	}
+	return PhaseError{Phase: p.name, Output: out, Err: err}
+}
+
+// installPhases returns the phases installing the emulator and the system image, then creating the AVD if createAVD is set.
+// The phase recreating the AVD is returned separately, boot recovery runs it to replace a corrupt AVD.
+// The system image is installed with imageInstaller if it is set, and with sdkmanager otherwise.
+// Likewise the AVD is created by nativeCreateAVD if it is set, and by avdmanager otherwise.
+func installPhases(cfg Config, sdkManagerPath, avdManagerPath string, createFlags []string, createAVD bool, imageInstaller systemImageInstaller, nativeCreateAVD func(ctx context.Context) error) (phases []phase, recreateAVD phase) {
+	var (
+		pkg     = fmt.Sprintf("system-images;android-%s;%s;%s", cfg.APILevel, cfg.Tag, cfg.Abi)
+		yes, no = strings.Repeat("yes\n", 20), strings.Repeat("no\n", 20)
+	)
+
+	systemImageChannel := "0"
+	if cfg.EmulatorChannel != emuChannelNoUpdate {
+		systemImageChannel = cfg.EmulatorChannel
+		phases = append(phases,
+			phase{
+				name:    "Updating emulator",
+				timeout: secondsToDuration(cfg.EmulatorUpdateTimeout),
+				cmdName: sdkManagerPath,
+				args:    []string{"--verbose", "--channel=" + cfg.EmulatorChannel, "emulator"},
+				stdin:   yes, // hitting yes in case it waits for accepting license
+			},
+		)
+	}
+
+	createAVDPhase := func(force bool) phase {
+		if nativeCreateAVD != nil {
+			return phase{
+				name:    "Creating device",
+				timeout: secondsToDuration(cfg.CreateAVDTimeout),
+				run:     nativeCreateAVD,
+			}
+		}
+
+		args := []string{"--verbose", "create", "avd"}
+		if force {
+			args = append(args, "--force")
+		}
+		args = append(args,
+			"--name", cfg.ID,
+			"--device", cfg.DeviceProfile,
+			"--package", pkg,
+			"--abi", cfg.Abi,
+		)
+		// ps16k images have a single valid avdmanager tag that varies by API level — let avdmanager auto-select it.
+		// For all other tags, pass explicitly.
+		if cfg.Tag != "google_apis_ps16k" && cfg.Tag != "google_apis_playstore_ps16k" {
+			args = append(args, "--tag", cfg.Tag)
+		}
+		args = append(args, createFlags...)
+		// An SD card set in create_command_flags takes precedence over sdcard_size.
+		if cfg.SDCardSize != "" && !sliceutil.IsStringInSlice("--sdcard", createFlags) && !sliceutil.IsStringInSlice("-c", createFlags) {
+			args = append(args, "--sdcard", cfg.SDCardSize)
+		}
+
+		return phase{
+			name:    "Creating device",
+			timeout: secondsToDuration(cfg.CreateAVDTimeout),
+			cmdName: avdManagerPath,
+			args:    args,
+			stdin:   no, // hitting no in case it asks for creating hw profile
+		}
+	}
+	// With fail-if-exists the AVD is known not to exist, avdmanager failing otherwise is a safety net.
+	// Recreating replaces the AVD created by the step, so it is always forced.
+	recreateAVD = createAVDPhase(true)
+
+	installSystemImage := phase{
+		name:    "Installing system image package",
+		timeout: secondsToDuration(cfg.SystemImageInstallTimeout),
+		cmdName: sdkManagerPath,
+		args:    []string{"--verbose", "--channel=" + systemImageChannel, pkg},
+		stdin:   yes, // hitting yes in case it waits for accepting license
+	}
+	if imageInstaller != nil {
+		// The channel is validated by the emulator_channel input options.
+		channel, _ := strconv.Atoi(systemImageChannel)
+		installSystemImage = phase{
+			name:    installSystemImage.name,
+			timeout: installSystemImage.timeout,
+			run: func(ctx context.Context) error {
+				return imageInstaller.Install(ctx, pkg, channel)
+			},
+		}
+	}
+
+	phases = append(phases, installSystemImage)
+	if createAVD {
+		phases = append(phases, createAVDPhase(cfg.ReuseAVD != reuseAVDFailIfExists))
+	}
+	return phases, recreateAVD
+}
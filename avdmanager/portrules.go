@@ -0,0 +1,63 @@
+package avdmanager
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+)
+
+func parsePortRules(lines []string) ([]adb.PortRule, error) {
+	var rules []adb.PortRule
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		rule, err := adb.ParsePortRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r Runner) applyPortRules(adbClient adb.ADB, serial string, rules []adb.PortRule) error {
+	r.logger.Println()
+	r.logger.Infof("Applying %d port rule(s)", len(rules))
+	return adbClient.ApplyPortRules(serial, rules)
+}
+
+// portRuleOutputs writes the script re-applying the port rules, and returns the rules and the script path as outputs.
+func (r Runner) portRuleOutputs(adbClient adb.ADB, serial string, rules []adb.PortRule) []stepOutput {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, rule := range rules {
+		lines = append(lines, rule.String())
+	}
+	outputs := []stepOutput{{"BITRISE_EMULATOR_PORT_RULES", strings.Join(lines, "\n")}}
+
+	scriptPath, err := r.writePortRulesScript(adbClient.PortRulesScript(serial, rules))
+	if err != nil {
+		r.logger.Warnf("Failed to write port rules script: %s", err)
+		return outputs
+	}
+	return append(outputs, stepOutput{"BITRISE_EMULATOR_PORT_RULES_SCRIPT", scriptPath})
+}
+
+func (r Runner) writePortRulesScript(script string) (string, error) {
+	dir, err := r.fs.MkdirTemp("", "avd-manager")
+	if err != nil {
+		return "", err
+	}
+
+	scriptPath := filepath.Join(dir, "apply_port_rules.sh")
+	if err := r.fs.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		return "", fmt.Errorf("write %s: %w", scriptPath, err)
+	}
+	return scriptPath, nil
+}
@@ -0,0 +1,134 @@
+package avdmanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bitrise-io/go-android/v2/adbmanager"
+	"github.com/bitrise-io/go-android/v2/sdk"
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+	"github.com/bitrise-steplib/steps-avd-manager/cacerts"
+	"github.com/bitrise-steplib/steps-avd-manager/deviceprep"
+)
+
+// devicePreparation is the work done on the booted device, resolved from the inputs before the emulator is started
+// so that invalid inputs fail the step early.
+type devicePreparation struct {
+	settings  []deviceprep.Item
+	apps      [][]string
+	files     []fileTransfer
+	portRules []adb.PortRule
+	caCerts   []cacerts.Certificate
+}
+
+func newDevicePreparation(cfg Config) (devicePreparation, error) {
+	settings, err := devicePreparationItems(cfg)
+	if err != nil {
+		return devicePreparation{}, err
+	}
+
+	apps, err := resolveApps(cfg.APKsToInstall)
+	if err != nil {
+		return devicePreparation{}, fmt.Errorf("apks_to_install: %w", err)
+	}
+
+	files, err := resolveFileTransfers(cfg.FilesToPush)
+	if err != nil {
+		return devicePreparation{}, fmt.Errorf("files_to_push: %w", err)
+	}
+
+	portRules, err := parsePortRules(cfg.PortRules)
+	if err != nil {
+		return devicePreparation{}, fmt.Errorf("port_rules: %w", err)
+	}
+
+	caCerts, err := cacerts.Load(nonEmptyLines(cfg.CACertificates))
+	if err != nil {
+		return devicePreparation{}, fmt.Errorf("ca_certificates: %w", err)
+	}
+
+	return devicePreparation{settings: settings, apps: apps, files: files, portRules: portRules, caCerts: caCerts}, nil
+}
+
+// devicePreparationItems collects the device settings to apply after boot, in order: disabled animations,
+// the preset, the proxy of a remote emulator, then the custom settings.
+func devicePreparationItems(cfg Config) ([]deviceprep.Item, error) {
+	var items []deviceprep.Item
+	if cfg.DisableAnimations {
+		items = append(items, deviceprep.AnimationsOff...)
+	}
+
+	presetItems, err := deviceprep.Preset(cfg.DeviceSettingsPreset)
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, presetItems...)
+	items = append(items, remoteProxySetting(cfg)...)
+
+	customItems, err := deviceprep.Load(cfg.DeviceSettings)
+	if err != nil {
+		return nil, fmt.Errorf("device_settings: %w", err)
+	}
+	return append(items, customItems...), nil
+}
+
+// prepareDevice waits for the boot to complete and applies the device configuration.
+func (r Runner) prepareDevice(ctx context.Context, cfg Config, androidSdk *sdk.Model, adbClient adb.ADB, serial string, preparation devicePreparation) error {
+	if len(preparation.settings) == 0 && len(preparation.apps) == 0 && len(preparation.files) == 0 && len(preparation.portRules) == 0 && len(preparation.caCerts) == 0 &&
+		!localizationEnabled(cfg) {
+		return nil
+	}
+
+	// We need to wait for the device to boot before we can change its settings
+	adbManager, err := adbmanager.New(androidSdk, r.cmdFactory, r.logger)
+	if err != nil {
+		return fmt.Errorf("failed to create ADB model: %s", err)
+	}
+	waitForBoot := func() error {
+		return adbManager.WaitForDevice(serial, secondsToDuration(cfg.BootTimeout))
+	}
+	if err := waitForBoot(); err != nil {
+		return err
+	}
+
+	r.logger.Println()
+	r.logger.Infof("Preparing device %s", serial)
+	// CA certificates go first, remounting the system partition might reboot the device
+	if len(preparation.caCerts) > 0 {
+		if err := r.installCACertificates(adbClient, serial, preparation.caCerts, waitForBoot); err != nil {
+			return fmt.Errorf("failed to install CA certificates: %w", err)
+		}
+	}
+	if _, err := deviceprep.NewPreparer(&adbClient, r.logger).Apply(serial, preparation.settings); err != nil {
+		return fmt.Errorf("failed to prepare device: %w", err)
+	}
+	if localizationEnabled(cfg) {
+		if err := r.applyLocalization(adbClient, serial, cfg); err != nil {
+			return fmt.Errorf("failed to apply locale, timezone and date: %w", err)
+		}
+	}
+	if len(preparation.apps) > 0 {
+		if err := r.installApps(ctx, adbClient, serial, preparation.apps, adb.InstallOptions{
+			GrantPermissions: cfg.GrantPermissions,
+			AllowTestOnly:    cfg.AllowTestOnlyAPKs,
+			MaxAttempts:      apkInstallAttempts,
+			RetryDelay:       apkInstallRetryDelay,
+			After:            r.clock.After,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(preparation.files) > 0 {
+		if err := r.pushFiles(adbClient, serial, preparation.files); err != nil {
+			return err
+		}
+	}
+	if len(preparation.portRules) > 0 {
+		if err := r.applyPortRules(adbClient, serial, preparation.portRules); err != nil {
+			return fmt.Errorf("failed to apply port rules: %w", err)
+		}
+	}
+	r.logger.Donef("Done")
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package avdmanager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	v1command "github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// processWaitDelay bounds how long we wait for the output pipes to close after a cancelled process was killed.
+const processWaitDelay = 10 * time.Second
+
+// CommandFactory creates commands, optionally bound to a context.
+type CommandFactory interface {
+	command.Factory
+	// CreateWithContext creates a command that is killed together with its child processes when ctx is done.
+	CreateWithContext(ctx context.Context, name string, args []string, opts *command.Opts) command.Command
+}
+
+type commandFactory struct {
+	command.Factory
+	envRepository env.Repository
+}
+
+// NewCommandFactory returns a command.Factory which can also create commands running in their own process group,
+// so that cancelling them doesn't leave child processes behind.
+func NewCommandFactory(envRepository env.Repository) CommandFactory {
+	return commandFactory{
+		Factory:       command.NewFactory(envRepository),
+		envRepository: envRepository,
+	}
+}
+
+func (f commandFactory) CreateWithContext(ctx context.Context, name string, args []string, opts *command.Opts) command.Command {
+	cmd := newCommandContext(ctx, name, args...)
+	if opts != nil {
+		cmd.Stdout = opts.Stdout
+		cmd.Stderr = opts.Stderr
+		cmd.Stdin = opts.Stdin
+		cmd.Env = append(f.envRepository.List(), opts.Env...)
+		cmd.Dir = opts.Dir
+	}
+	return processGroupCommand{cmd: cmd}
+}
+
+// newCommandContext returns a command that runs in its own process group. Cancelling ctx kills the whole group,
+// so wrapper scripts (sdkmanager, avdmanager) don't leave their JVM behind.
+func newCommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process)
+	}
+	cmd.WaitDelay = processWaitDelay
+	return cmd
+}
+
+// killProcessGroup kills the process and every child process in its group.
+// The process has to be started with Setpgid for this to reach the children.
+func killProcessGroup(process *os.Process) error {
+	if process == nil {
+		return nil
+	}
+
+	// A negative PID addresses the process group.
+	err := syscall.Kill(-process.Pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+type processGroupCommand struct {
+	cmd *exec.Cmd
+}
+
+func (c processGroupCommand) PrintableCommandArgs() string {
+	return v1command.PrintableCommandArgs(false, c.cmd.Args)
+}
+
+func (c processGroupCommand) Run() error {
+	return c.cmd.Run()
+}
+
+func (c processGroupCommand) RunAndReturnExitCode() (int, error) {
+	err := c.cmd.Run()
+	return c.cmd.ProcessState.ExitCode(), err
+}
+
+func (c processGroupCommand) RunAndReturnTrimmedOutput() (string, error) {
+	out, err := c.cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func (c processGroupCommand) RunAndReturnTrimmedCombinedOutput() (string, error) {
+	out, err := c.cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+func (c processGroupCommand) Start() error {
+	return c.cmd.Start()
+}
+
+func (c processGroupCommand) Wait() error {
+	return c.cmd.Wait()
+}
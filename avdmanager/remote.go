@@ -0,0 +1,93 @@
+package avdmanager
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+)
+
+const connectRemotePhase = "Connecting to remote emulator"
+
+// connectRemoteEmulator connects to an emulator running on another host with `adb connect`, waits for it to come
+// online and verifies that it runs the requested system image. The returned serial is the emulator address.
+func (r Runner) connectRemoteEmulator(ctx context.Context, adbClient adb.ADB, cfg Config) (string, error) {
+	address := cfg.RemoteEmulatorAddress
+
+	r.logger.Infof(connectRemotePhase)
+	r.logger.Donef("$ adb connect %s", address)
+	if err := adbClient.Connect(address); err != nil {
+		return "", err
+	}
+
+	if err := r.waitForRemoteDevice(ctx, adbClient, address, secondsToDuration(cfg.BootTimeout), secondsToDuration(cfg.BootCheckInterval)); err != nil {
+		return "", err
+	}
+
+	if err := verifyRemoteEmulator(adbClient, address, cfg.APILevel, cfg.Abi); err != nil {
+		return "", err
+	}
+	r.logger.Donef("Connected to %s", address)
+	r.logger.Println()
+
+	return address, nil
+}
+
+func (r Runner) waitForRemoteDevice(ctx context.Context, adbClient adb.ADB, serial string, timeout, checkInterval time.Duration) error {
+	timeoutCh := r.clock.After(timeout)
+	for {
+		devices, err := adbClient.ListDevices()
+		if err != nil {
+			return err
+		}
+
+		state := ""
+		for _, device := range devices {
+			if device.Serial == serial {
+				state = device.State
+			}
+		}
+		if state == adb.DeviceStateConnected {
+			return nil
+		}
+		r.logger.Printf("Waiting for %s to come online (state: %s)", serial, state)
+
+		select {
+		case <-ctx.Done():
+			return InterruptedError{Phase: connectRemotePhase, Cause: context.Cause(ctx)}
+		case <-timeoutCh:
+			return fmt.Errorf("%s didn't come online within %s, last state: %s", serial, timeout, state)
+		case <-r.clock.After(checkInterval):
+		}
+	}
+}
+
+// verifyRemoteEmulator checks that the device is an emulator with the expected API level and ABI.
+func verifyRemoteEmulator(adbClient adb.ADB, serial, apiLevel, abi string) error {
+	properties, err := adbClient.Properties(serial)
+	if err != nil {
+		return err
+	}
+
+	if !properties.IsEmulator() {
+		return fmt.Errorf("%s is not an emulator", serial)
+	}
+
+	sdkInt, err := properties.SDKInt()
+	if err != nil {
+		return err
+	}
+	if strconv.Itoa(sdkInt) != apiLevel {
+		return fmt.Errorf("%s runs API level %d, expected %s", serial, sdkInt, apiLevel)
+	}
+
+	abiList := properties.ABIList()
+	if len(abiList) == 0 || abiList[0] != abi {
+		return fmt.Errorf("%s runs ABI %s, expected %s", serial, strings.Join(abiList, ","), abi)
+	}
+
+	return nil
+}
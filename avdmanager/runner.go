@@ -0,0 +1,450 @@
+// Package avdmanager installs, creates and boots an Android emulator, or connects to a remote one,
+// then prepares the device for testing.
+package avdmanager
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-android/v2/sdk"
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/retryhttp"
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+	"github.com/bitrise-steplib/steps-avd-manager/avd"
+	"github.com/bitrise-steplib/steps-avd-manager/devices"
+	"github.com/bitrise-steplib/steps-avd-manager/emuinstaller"
+	"github.com/bitrise-steplib/steps-avd-manager/hostcheck"
+	"github.com/bitrise-steplib/steps-avd-manager/recovery"
+	"github.com/bitrise-steplib/steps-avd-manager/sysimg"
+	"github.com/kballard/go-shellquote"
+)
+
+// Runner runs the step.
+type Runner struct {
+	cmdFactory    CommandFactory
+	logger        log.Logger
+	clock         Clock
+	fs            FileSystem
+	envRepository env.Repository
+	exporter      OutputExporter
+	procRoot      string
+}
+
+// NewRunner returns a Runner running commands with cmdFactory, timing with clock, writing files to fs
+// and exporting the outputs with exporter.
+func NewRunner(cmdFactory CommandFactory, logger log.Logger, clock Clock, fs FileSystem, envRepository env.Repository, exporter OutputExporter) Runner {
+	return Runner{
+		cmdFactory:    cmdFactory,
+		logger:        logger,
+		clock:         clock,
+		fs:            fs,
+		envRepository: envRepository,
+		exporter:      exporter,
+		procRoot:      "/proc",
+	}
+}
+
+// emulatorLogs are the log files of the emulator process and of the device. They are captured on every run for
+// failure diagnostics, but only kept after a successful boot when requested with host_debug_tags/device_logcat_tags.
+type emulatorLogs struct {
+	hostLog       string
+	logcat        string
+	keepHostLog   bool
+	keepLogcat    bool
+	debugTags     string
+	logcatTags    string
+	captureLogcat bool
+}
+
+// Run validates the config, then boots the emulator (or connects to the remote one), prepares the device
+// and exports the outputs. The outputs identifying the emulator and its logs are exported even if the boot fails.
+func (r Runner) Run(ctx context.Context, cfg Config) error {
+	if err := validateConfig(cfg); err != nil {
+		return InputError{Err: err}
+	}
+
+	createFlags, err := shellquote.Split(cfg.CreateCommandArgs)
+	if err != nil {
+		return InputError{Err: fmt.Errorf("failed to parse create_command_flags: %w", err)}
+	}
+	startFlags, err := shellquote.Split(cfg.StartCommandArgs)
+	if err != nil {
+		return InputError{Err: fmt.Errorf("failed to parse start_command_flags: %w", err)}
+	}
+	if err := checkFlagConflicts(cfg, startFlags); err != nil {
+		return err
+	}
+
+	preparation, err := newDevicePreparation(cfg)
+	if err != nil {
+		return InputError{Err: err}
+	}
+
+	if cfg.StepTimeout > 0 {
+		stepTimeout := secondsToDuration(cfg.StepTimeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, stepTimeout, fmt.Errorf("step timed out after %s", stepTimeout))
+		defer cancel()
+	}
+
+	// Initialize Android SDK
+	r.logger.Infof("Initialize Android SDK")
+	androidSdk, err := sdk.New(cfg.AndroidHome)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Android SDK: %w", err)
+	}
+
+	adbClient := adb.New(cfg.AndroidHome, r.cmdFactory, r.logger)
+
+	if cfg.RemoteEmulatorAddress != "" {
+		return r.runRemote(ctx, cfg, androidSdk, adbClient, preparation)
+	}
+	return r.runLocal(ctx, cfg, createFlags, startFlags, androidSdk, adbClient, preparation)
+}
+
+func (r Runner) runRemote(ctx context.Context, cfg Config, androidSdk *sdk.Model, adbClient adb.ADB, preparation devicePreparation) error {
+	serial, err := r.connectRemoteEmulator(ctx, adbClient, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to remote emulator: %w", err)
+	}
+	if err := r.prepareDevice(ctx, cfg, androidSdk, adbClient, serial, preparation); err != nil {
+		return err
+	}
+
+	outputs := []stepOutput{{"BITRISE_EMULATOR_SERIAL", serial}}
+	outputs = append(outputs, r.deviceOutputs(adbClient, serial)...)
+	outputs = append(outputs, r.portRuleOutputs(adbClient, serial, preparation.portRules)...)
+	r.exportOutputs(outputs)
+	return nil
+}
+
+func (r Runner) runLocal(ctx context.Context, cfg Config, createFlags, startFlags []string, androidSdk *sdk.Model, adbClient adb.ADB, preparation devicePreparation) error {
+	catalog, err := devices.Load(cfg.AndroidHome, r.envRepository, r.logger)
+	if err != nil {
+		return fmt.Errorf("failed to load device profiles: %w", err)
+	}
+	device, err := r.resolveDevice(cfg, catalog)
+	if err != nil {
+		return InputError{Err: err}
+	}
+	// The profile might be given by name, avdmanager only accepts the ID.
+	cfg.DeviceProfile = device.ID
+
+	runningDevicesBeforeBoot, err := adbClient.Devices()
+	if err != nil {
+		return fmt.Errorf("failed to check running devices: %w", err)
+	}
+
+	cmdlineToolsPath, err := androidSdk.CmdlineToolsPath()
+	if err != nil {
+		return fmt.Errorf("could not locate Android command-line tools: %w", err)
+	}
+
+	var (
+		sdkManagerPath = filepath.Join(cmdlineToolsPath, "sdkmanager")
+		avdManagerPath = filepath.Join(cmdlineToolsPath, "avdmanager")
+		emulatorPath   = filepath.Join(cfg.AndroidHome, "emulator", "emulator")
+	)
+
+	avdHome, err := avd.HomeDir(r.envRepository)
+	if err != nil {
+		return fmt.Errorf("failed to locate AVD home: %w", err)
+	}
+	avdDir := avd.Dir(avdHome, cfg.ID)
+
+	if cfg.HostCheck {
+		imageBytes := uint64(estimatedSystemImageBytes)
+		systemImageDir := filepath.Join(cfg.AndroidHome, "system-images", "android-"+cfg.APILevel, cfg.Tag, cfg.Abi)
+		if _, err := r.fs.Stat(systemImageDir); err == nil {
+			imageBytes = 0
+		}
+
+		if err := r.checkHost(hostcheck.Config{
+			EmulatorPath: emulatorPath,
+			AndroidHome:  cfg.AndroidHome,
+			AVDHome:      avdHome,
+			ImageBytes:   imageBytes,
+			AVDBytes:     estimatedAVDBytes,
+			RAMMegabytes: r.ramMegabytes(cfg, startFlags, device, avdDir),
+		}); err != nil {
+			return fmt.Errorf("host check failed: %w", err)
+		}
+	}
+
+	if cfg.EmulatorBuildNumber != emuBuildNumberPreinstalled {
+		httpClient := retryhttp.NewClient(r.logger)
+		var opts []emuinstaller.Option
+		if cfg.EmulatorDownloadBaseURL != "" {
+			opts = append(opts, emuinstaller.WithDownloadBaseURL(cfg.EmulatorDownloadBaseURL))
+		}
+		emuInstaller := emuinstaller.NewEmuInstaller(cfg.AndroidHome, r.cmdFactory, r.logger, httpClient, opts...)
+		err := r.runPhase(ctx, phase{
+			name:    fmt.Sprintf("Installing emulator build %s", cfg.EmulatorBuildNumber),
+			timeout: secondsToDuration(cfg.EmulatorUpdateTimeout),
+			run: func(ctx context.Context) error {
+				return emuInstaller.Install(ctx, cfg.EmulatorBuildNumber)
+			},
+		})
+		if err != nil {
+			return err
+		}
+		r.logger.Println()
+	}
+
+	var imageInstaller systemImageInstaller
+	if cfg.SystemImageInstaller == systemImageInstallerNative {
+		imageInstaller = sysimg.NewInstaller(cfg.AndroidHome, retryhttp.NewClient(r.logger), r.logger)
+	}
+
+	var nativeCreateAVD func(ctx context.Context) error
+	if cfg.AVDCreator == avdCreatorNative {
+		nativeCreateAVD = func(context.Context) error {
+			return r.createAVD(cfg, avdHome, device)
+		}
+	}
+
+	// Stale emulators of the AVD are stopped before it gets recreated under them.
+	if err := r.checkStaleEmulators(avdDir, cfg.ID, cfg.KillStaleEmulators); err != nil {
+		return fmt.Errorf("failed to clean up stale emulators: %w", err)
+	}
+
+	createAVD, err := r.applyReusePolicy(cfg, avdHome, device)
+	if err != nil {
+		return err
+	}
+
+	phases, recreateAVDPhase := installPhases(cfg, sdkManagerPath, avdManagerPath, createFlags, createAVD, imageInstaller, nativeCreateAVD)
+	for _, phase := range phases {
+		if err := r.runPhase(ctx, phase); err != nil {
+			return err
+		}
+
+		r.logger.Println()
+	}
+
+	logs := r.newEmulatorLogs(cfg, startFlags)
+	args := emulatorArgs(cfg, startFlags, preparation, logs, createAVD)
+
+	serial, bootErr := r.bootEmulator(ctx, adbClient, bootConfig{
+		emulatorPath:  emulatorPath,
+		args:          args,
+		logPath:       logs.hostLog,
+		avdDir:        avdDir,
+		timeout:       secondsToDuration(cfg.BootTimeout),
+		checkInterval: secondsToDuration(cfg.BootCheckInterval),
+		maxAttempts:   cfg.MaxBootAttempts,
+		policy:        recovery.DefaultPolicy(),
+		recreateAVD: func(ctx context.Context) error {
+			return r.runPhase(ctx, recreateAVDPhase)
+		},
+	}, runningDevicesBeforeBoot)
+
+	if bootErr == nil {
+		logs = r.cleanupLogs(logs)
+
+		if err := r.prepareDevice(ctx, cfg, androidSdk, adbClient, serial, preparation); err != nil {
+			return err
+		}
+	}
+
+	outputs := []stepOutput{
+		{"BITRISE_EMULATOR_SERIAL", serial},
+		{"BITRISE_EMULATOR_HOST_LOG", logs.hostLog},
+		{"BITRISE_EMULATOR_DEVICE_LOGCAT_LOG", logs.logcat},
+	}
+	if bootErr == nil {
+		outputs = append(outputs, r.deviceOutputs(adbClient, serial)...)
+		outputs = append(outputs, r.portRuleOutputs(adbClient, serial, preparation.portRules)...)
+	}
+	r.exportOutputs(outputs)
+
+	return bootErr
+}
+
+// applyReusePolicy checks the existing AVD with the same ID against the reuse_avd policy and the resolved device,
+// and returns whether the AVD has to be created.
+func (r Runner) applyReusePolicy(cfg Config, avdHome string, device devices.Device) (bool, error) {
+	if cfg.ReuseAVD != reuseAVDIfCompatible && cfg.ReuseAVD != reuseAVDFailIfExists {
+		return true, nil
+	}
+
+	exists, err := avd.Exists(avdHome, cfg.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check existing AVD: %w", err)
+	}
+	if !exists {
+		return true, nil
+	}
+	if cfg.ReuseAVD == reuseAVDFailIfExists {
+		return false, fmt.Errorf("AVD %s already exists in %s, remove it or set reuse_avd to recreate or reuse-if-compatible", cfg.ID, avdHome)
+	}
+
+	req := avd.Requirements{
+		ImageDir: filepath.Join("system-images", "android-"+cfg.APILevel, cfg.Tag, cfg.Abi),
+		ABI:      cfg.Abi,
+		Tag:      cfg.Tag,
+		Device:   device.ID,
+	}
+	// The tag ID of the 16 KB page size images is their base tag, the image directory already tells them apart.
+	if cfg.Tag == "google_apis_ps16k" || cfg.Tag == "google_apis_playstore_ps16k" {
+		req.Tag = ""
+	}
+	mismatches, err := avd.Mismatches(avd.Dir(avdHome, cfg.ID), req)
+	if err != nil {
+		r.logger.Warnf("Recreating AVD %s, it can't be checked: %s", cfg.ID, err)
+		return true, nil
+	}
+	if len(mismatches) > 0 {
+		r.logger.Warnf("Recreating AVD %s, it isn't compatible with the inputs:", cfg.ID)
+		for _, mismatch := range mismatches {
+			r.logger.Warnf("- %s", mismatch)
+		}
+		return true, nil
+	}
+
+	r.logger.Donef("Reusing AVD %s", cfg.ID)
+	return false, nil
+}
+
+// resolveDevice looks up the device profile by ID or name in the catalog and logs its specs.
+// avdmanager reads its own catalog, which gains profiles with every release, so an unknown profile is passed to it
+// as is with a warning. It is only an error for the native AVD creator, which depends on the catalog.
+func (r Runner) resolveDevice(cfg Config, catalog devices.Catalog) (devices.Device, error) {
+	device, err := catalog.Lookup(cfg.DeviceProfile)
+	if err != nil {
+		if cfg.AVDCreator == avdCreatorNative {
+			return devices.Device{}, fmt.Errorf("invalid profile: %w", err)
+		}
+		r.logger.Warnf("Passing the device profile to avdmanager as is: %s", err)
+		return devices.Device{ID: cfg.DeviceProfile}, nil
+	}
+
+	r.logger.Printf("Device profile: %s by %s (%s): %s", device.Name, device.Manufacturer, device.Source, device.Specs())
+	if device.TagID != "" && device.TagID != cfg.Tag {
+		r.logger.Warnf("Device profile %s is meant for %s system images, not %s", device.ID, device.TagID, cfg.Tag)
+	}
+	return device, nil
+}
+
+// createAVD creates the AVD from the installed system image without avdmanager.
+func (r Runner) createAVD(cfg Config, avdHome string, device devices.Device) error {
+	image, err := avd.ReadSystemImage(cfg.AndroidHome, filepath.Join("system-images", "android-"+cfg.APILevel, cfg.Tag, cfg.Abi))
+	if err != nil {
+		return err
+	}
+
+	creator := avd.NewCreator(cfg.AndroidHome, avdHome, r.cmdFactory, r.logger)
+	return creator.Create(avd.CreateConfig{
+		ID:         cfg.ID,
+		Image:      image,
+		Device:     device,
+		SDCardSize: cfg.SDCardSize,
+	})
+}
+
+// checkFlagConflicts detects debug/logcat flags already present in start_command_flags.
+func checkFlagConflicts(cfg Config, startFlags []string) error {
+	// The step always passes -debug.
+	if sliceutil.IsStringInSlice("-debug", startFlags) || sliceutil.IsStringInSlice("-verbose", startFlags) {
+		return FlagConflictError{Flags: []string{"-debug", "-verbose"}, Input: "host_debug_tags"}
+	}
+	if hasLogcatFlags(startFlags) && logcatEnabled(cfg) {
+		return FlagConflictError{Flags: []string{"-logcat", "-logcat-output"}, Input: "device_logcat_tags"}
+	}
+	return nil
+}
+
+func hasLogcatFlags(startFlags []string) bool {
+	return sliceutil.IsStringInSlice("-logcat", startFlags) || sliceutil.IsStringInSlice("-logcat-output", startFlags)
+}
+
+func debugEnabled(cfg Config) bool {
+	return cfg.HostDebugTags != "" && cfg.HostDebugTags != "none"
+}
+
+func logcatEnabled(cfg Config) bool {
+	return cfg.DeviceLogcatTags != "" && cfg.DeviceLogcatTags != "none"
+}
+
+func (r Runner) newEmulatorLogs(cfg Config, startFlags []string) emulatorLogs {
+	logs := emulatorLogs{
+		keepHostLog: debugEnabled(cfg),
+		keepLogcat:  logcatEnabled(cfg),
+		// Always pass -debug; use the user-specified tags or the default when host_debug_tags is not set.
+		debugTags:  "init,avd,kernel,snapshot",
+		logcatTags: "*:w",
+	}
+	if logs.keepHostLog {
+		logs.debugTags = cfg.HostDebugTags
+	}
+	if logs.keepLogcat {
+		logs.logcatTags = cfg.DeviceLogcatTags
+	}
+	if cfg.DeployDir == "" {
+		return logs
+	}
+
+	// Timestamp embedded in filenames ensures uniqueness across retries and concurrent runs.
+	runID := r.clock.Now().Format("20060102_150405")
+	logs.hostLog = filepath.Join(cfg.DeployDir, cfg.ID+"_"+runID+hostLogSuffix)
+
+	// Capture logcat for failure diagnostics unless the user already handles it via start_command_flags.
+	if !hasLogcatFlags(startFlags) {
+		logs.captureLogcat = true
+		logs.logcat = filepath.Join(cfg.DeployDir, cfg.ID+"_"+runID+deviceLogcatSuffix)
+	}
+	return logs
+}
+
+// cleanupLogs deletes the logs that weren't explicitly requested, they were captured for diagnostics only.
+func (r Runner) cleanupLogs(logs emulatorLogs) emulatorLogs {
+	if logs.hostLog != "" && !logs.keepHostLog {
+		if err := r.fs.Remove(logs.hostLog); err != nil {
+			r.logger.Warnf("Failed to remove emulator host log: %s", err)
+		}
+		logs.hostLog = ""
+	}
+	if logs.logcat != "" && !logs.keepLogcat {
+		if err := r.fs.Remove(logs.logcat); err != nil {
+			r.logger.Warnf("Failed to remove device logcat log: %s", err)
+		}
+		logs.logcat = ""
+	}
+	return logs
+}
+
+// emulatorArgs returns the args of the emulator. The user data is wiped unless the AVD is reused,
+// a reused AVD keeps the state it was prewarmed with.
+func emulatorArgs(cfg Config, startFlags []string, preparation devicePreparation, logs emulatorLogs, wipeData bool) []string {
+	args := []string{
+		"@" + cfg.ID,
+		"-show-kernel",
+		"-no-audio",
+		"-no-snapshot",
+	}
+	if wipeData {
+		args = append(args, "-wipe-data")
+	}
+	args = append(args, networkArgs(cfg, startFlags)...)
+	if len(preparation.caCerts) > 0 && !sliceutil.IsStringInSlice("-writable-system", startFlags) {
+		args = append(args, "-writable-system")
+	}
+	if !sliceutil.IsStringInSlice("-gpu", startFlags) {
+		args = append(args, []string{"-gpu", "auto"}...)
+	}
+	if cfg.IsHeadlessMode {
+		args = append(args, []string{"-no-window", "-no-boot-anim"}...)
+	}
+	if cfg.Locale != "" {
+		args = append(args, "-change-locale", cfg.Locale)
+	}
+	args = append(args, "-debug", logs.debugTags)
+	if logs.captureLogcat {
+		args = append(args, "-logcat", logs.logcatTags, "-logcat-output", logs.logcat)
+	}
+
+	return append(args, startFlags...)
+}
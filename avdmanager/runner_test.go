@@ -0,0 +1,596 @@
+package avdmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/adb"
+	"github.com/bitrise-steplib/steps-avd-manager/avd"
+	"github.com/bitrise-steplib/steps-avd-manager/devices"
+	"github.com/bitrise-steplib/steps-avd-manager/test"
+	"github.com/stretchr/testify/require"
+)
+
+var fakeNow = time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)
+
+func newTestRunner(cmdFactory CommandFactory, exporter OutputExporter) Runner {
+	return NewRunner(cmdFactory, log.NewLogger(), test.FakeClock{Time: fakeNow}, NewFileSystem(), env.NewRepository(), exporter)
+}
+
+func validConfig() Config {
+	return Config{
+		AndroidHome:         "/fake/android/home",
+		APILevel:            "34",
+		Tag:                 "google_apis",
+		DeviceProfile:       "pixel",
+		ID:                  "emulator",
+		Abi:                 "x86_64",
+		EmulatorChannel:     emuChannelNoUpdate,
+		EmulatorBuildNumber: emuBuildNumberPreinstalled,
+		BootTimeout:         600,
+		BootCheckInterval:   5,
+		MaxBootAttempts:     1,
+		NetworkSpeed:        networkSpeedFull,
+		NetworkDelay:        networkDelayNone,
+	}
+}
+
+func TestRun_InputErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr error
+	}{
+		{
+			name: "invalid config",
+			modify: func(cfg *Config) {
+				cfg.BootTimeout = 0
+			},
+			wantErr: InputError{},
+		},
+		{
+			name: "invalid emulator download base URL",
+			modify: func(cfg *Config) {
+				cfg.EmulatorBuildNumber = "12038310"
+				cfg.EmulatorDownloadBaseURL = "redirector.gvt1.com/edgedl/android/repository"
+			},
+			wantErr: InputError{},
+		},
+		{
+			name: "invalid SD card size",
+			modify: func(cfg *Config) {
+				cfg.SDCardSize = "2 GB"
+			},
+			wantErr: InputError{},
+		},
+		{
+			name: "avdmanager flags with the native AVD creator",
+			modify: func(cfg *Config) {
+				cfg.AVDCreator = avdCreatorNative
+				cfg.CreateCommandArgs = "--sdcard 512M"
+			},
+			wantErr: InputError{},
+		},
+		{
+			name: "network latency of a remote emulator",
+			modify: func(cfg *Config) {
+				cfg.RemoteEmulatorAddress = "10.0.0.5:5555"
+				cfg.NetworkDelay = "umts"
+			},
+			wantErr: InputError{},
+		},
+		{
+			name: "unparsable start flags",
+			modify: func(cfg *Config) {
+				cfg.StartCommandArgs = `-prop "unterminated`
+			},
+			wantErr: InputError{},
+		},
+		{
+			name: "debug flag conflict",
+			modify: func(cfg *Config) {
+				cfg.StartCommandArgs = "-verbose"
+			},
+			wantErr: FlagConflictError{Flags: []string{"-debug", "-verbose"}, Input: "host_debug_tags"},
+		},
+		{
+			name: "logcat flag conflict",
+			modify: func(cfg *Config) {
+				cfg.StartCommandArgs = "-logcat *:e"
+				cfg.DeviceLogcatTags = "*:v"
+			},
+			wantErr: FlagConflictError{Flags: []string{"-logcat", "-logcat-output"}, Input: "device_logcat_tags"},
+		},
+		{
+			name: "missing APK",
+			modify: func(cfg *Config) {
+				cfg.APKsToInstall = []string{filepath.Join(t.TempDir(), "missing.apk")}
+			},
+			wantErr: InputError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+
+			exporter := test.NewFakeOutputExporter()
+			err := newTestRunner(test.FakeCommandFactory{}, exporter).Run(context.Background(), cfg)
+
+			switch wantErr := tt.wantErr.(type) {
+			case InputError:
+				require.ErrorAs(t, err, &wantErr)
+			case FlagConflictError:
+				require.Equal(t, wantErr, err)
+			}
+			require.Empty(t, exporter.Outputs)
+		})
+	}
+}
+
+// newTestAndroidHome returns an Android SDK with the command-line tools, platform-tools and emulator
+// the step locates, and sets an empty AVD home and Android user home.
+func newTestAndroidHome(t *testing.T) string {
+	androidHome := t.TempDir()
+	for _, pth := range []string{
+		filepath.Join("cmdline-tools", "latest", "bin", "sdkmanager"),
+		filepath.Join("cmdline-tools", "latest", "bin", "avdmanager"),
+		filepath.Join("platform-tools", "adb"),
+		filepath.Join("emulator", "emulator"),
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(androidHome, filepath.Dir(pth)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(androidHome, pth), nil, 0755))
+	}
+	t.Setenv("ANDROID_AVD_HOME", t.TempDir())
+	t.Setenv("ANDROID_USER_HOME", t.TempDir())
+	return androidHome
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		emulator    test.Response
+		devices     []test.Response
+		wantErr     string
+		wantTools   []string
+		wantOutputs map[string]string
+	}{
+		{
+			name:     "boots",
+			emulator: test.Response{UntilKilled: true},
+			devices:  []test.Response{{Stdout: noDevicesOutput}, {Stdout: newDeviceOutput}},
+			// The devices running before the boot are listed first, the booted one is waited for and queried last.
+			wantTools: []string{"adb", "sdkmanager", "avdmanager", "emulator", "adb", "adb"},
+			wantOutputs: map[string]string{
+				"BITRISE_EMULATOR_SERIAL":      "emulator-5556",
+				"BITRISE_EMULATOR_FINGERPRINT": "google/sdk_gphone64_x86_64/emu64xa:14/UE1A.230829.036/10747361:userdebug/dev-keys",
+				"BITRISE_EMULATOR_SDK_INT":     "34",
+			},
+		},
+		{
+			name:      "boot fails",
+			emulator:  test.Response{Stdout: "FATAL | Running multiple emulators with the same AVD\n", ExitCode: 1},
+			devices:   []test.Response{{Stdout: noDevicesOutput}},
+			wantErr:   "emulator exited early",
+			wantTools: []string{"adb", "sdkmanager", "avdmanager", "emulator"},
+			// The logs are kept for the failure diagnostics.
+			wantOutputs: map[string]string{
+				"BITRISE_EMULATOR_HOST_LOG":          "$DEPLOY_DIR/emulator_20240131_090000_host.log",
+				"BITRISE_EMULATOR_DEVICE_LOGCAT_LOG": "$DEPLOY_DIR/emulator_20240131_090000_device_logcat.log",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdFactory := test.NewScriptedCommandFactory().
+				On(`^sdkmanager `).
+				On(`^avdmanager `).
+				On(`^emulator `, tt.emulator).
+				On(`^adb devices -l$`, tt.devices...).
+				On(`^adb -s emulator-5556 shell getprop$`, test.Response{Stdout: "[ro.build.version.sdk]: [34]\n" +
+					"[ro.build.fingerprint]: [google/sdk_gphone64_x86_64/emu64xa:14/UE1A.230829.036/10747361:userdebug/dev-keys]\n"})
+			exporter := test.NewFakeOutputExporter()
+			deployDir := t.TempDir()
+
+			cfg := validConfig()
+			cfg.AndroidHome = newTestAndroidHome(t)
+			cfg.DeployDir = deployDir
+			cfg.ReuseAVD = "recreate"
+			cfg.AVDCreator = "avdmanager"
+			cfg.SystemImageInstaller = "sdkmanager"
+			cfg.BootCheckInterval = 1
+
+			err := newTestRunner(cmdFactory, exporter).Run(context.Background(), cfg)
+
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Empty(t, cmdFactory.Unmatched())
+			var tools []string
+			for _, invocation := range cmdFactory.Invocations() {
+				tools = append(tools, filepath.Base(invocation.Name))
+			}
+			require.Equal(t, tt.wantTools, tools)
+			for key, value := range exporter.Outputs {
+				exporter.Outputs[key] = strings.ReplaceAll(value, deployDir, "$DEPLOY_DIR")
+			}
+			require.Equal(t, tt.wantOutputs, exporter.Outputs)
+		})
+	}
+}
+
+func TestResolveDevice(t *testing.T) {
+	runner := newTestRunner(test.FakeCommandFactory{}, test.NewFakeOutputExporter())
+	builtin, err := devices.Builtin()
+	require.NoError(t, err)
+	catalog := devices.NewCatalog(builtin...)
+	cfg := validConfig()
+
+	device, err := runner.resolveDevice(cfg, catalog)
+	require.NoError(t, err)
+	require.Equal(t, "Pixel", device.Name)
+
+	cfg.DeviceProfile = "Pixel 7 Pro"
+	device, err = runner.resolveDevice(cfg, catalog)
+	require.NoError(t, err)
+	require.Equal(t, "pixel_7_pro", device.ID)
+
+	// avdmanager might know profiles newer than the catalog.
+	cfg.DeviceProfile = "pixel_10"
+	device, err = runner.resolveDevice(cfg, catalog)
+	require.NoError(t, err)
+	require.Equal(t, devices.Device{ID: "pixel_10"}, device)
+
+	cfg.AVDCreator = avdCreatorNative
+	cfg.DeviceProfile = "pixle"
+	_, err = runner.resolveDevice(cfg, catalog)
+	require.EqualError(t, err, "invalid profile: unknown device profile: pixle, did you mean: pixel?")
+}
+
+func TestApplyReusePolicy(t *testing.T) {
+	const compatibleConfig = "abi.type=x86_64\nhw.device.name=pixel\nimage.sysdir.1=system-images/android-34/google_apis/x86_64/\ntag.id=google_apis\n"
+
+	tests := []struct {
+		name       string
+		policy     string
+		config     string
+		profile    string
+		wantCreate bool
+		wantErr    string
+	}{
+		{name: "recreate", policy: "recreate", config: compatibleConfig, wantCreate: true},
+		{name: "reuse compatible", policy: reuseAVDIfCompatible, config: compatibleConfig, wantCreate: false},
+		{name: "reuse incompatible", policy: reuseAVDIfCompatible, config: strings.Replace(compatibleConfig, "pixel", "pixel_7", 1), wantCreate: true},
+		{name: "reuse profile given by name", policy: reuseAVDIfCompatible, config: compatibleConfig, profile: "Pixel", wantCreate: false},
+		{name: "reuse missing", policy: reuseAVDIfCompatible, wantCreate: true},
+		{name: "fail if exists", policy: reuseAVDFailIfExists, config: compatibleConfig, wantErr: "AVD emulator already exists"},
+		{name: "fail if exists, missing", policy: reuseAVDFailIfExists, wantCreate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			avdHome := t.TempDir()
+			if tt.config != "" {
+				avdDir := avd.Dir(avdHome, "emulator")
+				require.NoError(t, os.MkdirAll(avdDir, 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(avdDir, avd.ConfigFileName), []byte(tt.config), 0644))
+			}
+			cfg := validConfig()
+			cfg.ReuseAVD = tt.policy
+			if tt.profile != "" {
+				cfg.DeviceProfile = tt.profile
+			}
+			builtin, err := devices.Builtin()
+			require.NoError(t, err)
+			device, err := devices.NewCatalog(builtin...).Lookup(cfg.DeviceProfile)
+			require.NoError(t, err)
+
+			create, err := newTestRunner(test.FakeCommandFactory{}, test.NewFakeOutputExporter()).applyReusePolicy(cfg, avdHome, device)
+
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCreate, create)
+		})
+	}
+}
+
+func TestRAMMegabytes(t *testing.T) {
+	avdDir := avd.Dir(t.TempDir(), "emulator")
+	require.NoError(t, os.MkdirAll(avdDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(avdDir, avd.ConfigFileName), []byte("hw.ramSize=3072\n"), 0644))
+	device := devices.Device{ID: "pixel_7", RAMMegabytes: 4096}
+
+	tests := []struct {
+		name       string
+		reuse      string
+		startFlags []string
+		device     devices.Device
+		avdDir     string
+		want       uint64
+	}{
+		{name: "memory flag", reuse: reuseAVDIfCompatible, startFlags: []string{"-memory", "1536"}, device: device, avdDir: avdDir, want: 1536},
+		{name: "reused AVD", reuse: reuseAVDIfCompatible, device: device, avdDir: avdDir, want: 3072},
+		{name: "recreated AVD", reuse: "recreate", device: device, avdDir: avdDir, want: 4096},
+		{name: "missing AVD", reuse: reuseAVDIfCompatible, device: device, avdDir: filepath.Join(t.TempDir(), "missing.avd"), want: 4096},
+		{name: "unknown RAM size", reuse: "recreate", avdDir: avdDir, want: defaultRAMMegabytes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.ReuseAVD = tt.reuse
+
+			got := newTestRunner(test.FakeCommandFactory{}, test.NewFakeOutputExporter()).ramMegabytes(cfg, tt.startFlags, tt.device, tt.avdDir)
+
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateNetwork(t *testing.T) {
+	tests := []struct {
+		speed   string
+		delay   string
+		wantErr string
+	}{
+		{speed: "full", delay: "none"},
+		{speed: "gsm", delay: "gsm"},
+		{speed: "hscsd", delay: "hscsd"},
+		{speed: "gprs", delay: "gprs"},
+		{speed: "edge", delay: "edge"},
+		{speed: "umts", delay: "umts"},
+		{speed: "hsdpa", delay: "hsdpa"},
+		{speed: "lte", delay: "lte"},
+		{speed: "evdo", delay: "evdo"},
+		{speed: "1024", delay: "200"},
+		{speed: "128:1024", delay: "150:550"},
+		{speed: "5g", delay: "none", wantErr: "network_speed: must be one of gsm, hscsd, gprs, edge, umts, hsdpa, lte, evdo, full, a number or a <min>:<max> pair, got 5g"},
+		{speed: "full", delay: "full", wantErr: "network_delay: must be one of gsm, hscsd, gprs, edge, umts, hsdpa, lte, evdo, none, a number or a <min>:<max> pair, got full"},
+		{speed: "full", delay: "150-550", wantErr: "network_delay: must be one of"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.speed+" "+tt.delay, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.NetworkSpeed = tt.speed
+			cfg.NetworkDelay = tt.delay
+
+			err := validateNetwork(cfg)
+
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveApps(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"app.apk", "helper-a.apk", "helper-b.apk", "base.apk", "split_en.apk", "split_xxhdpi.apk", "notes.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	apps, err := resolveApps([]string{
+		filepath.Join(dir, "app.apk"),
+		filepath.Join(dir, "helper-*.apk"),
+		"",
+		filepath.Join(dir, "base.apk") + ", " + filepath.Join(dir, "split_*.apk"),
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{filepath.Join(dir, "app.apk")},
+		{filepath.Join(dir, "helper-a.apk")},
+		{filepath.Join(dir, "helper-b.apk")},
+		{filepath.Join(dir, "base.apk"), filepath.Join(dir, "split_en.apk"), filepath.Join(dir, "split_xxhdpi.apk")},
+	}, apps)
+
+	_, err = resolveApps([]string{filepath.Join(dir, "*.txt")})
+	require.ErrorContains(t, err, "notes.txt is not an APK")
+	_, err = resolveApps([]string{filepath.Join(dir, "base.apk") + "," + filepath.Join(dir, "missing_*.apk")})
+	require.ErrorContains(t, err, "no APK found at")
+}
+
+func TestInstallApps_Canceled(t *testing.T) {
+	cmdFactory := test.NewScriptedCommandFactory()
+	adbClient := adb.New("/fake/android/home", cmdFactory, log.NewLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := newTestRunner(cmdFactory, test.NewFakeOutputExporter()).installApps(ctx, adbClient, "emulator-5554", [][]string{{"app.apk"}, {"helper.apk"}}, adb.InstallOptions{MaxAttempts: 1})
+
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, cmdFactory.Commands())
+}
+
+func TestCheckFlagConflicts(t *testing.T) {
+	cfg := validConfig()
+	require.NoError(t, checkFlagConflicts(cfg, []string{"-logcat", "*:e"}))
+
+	cfg.DeviceLogcatTags = "none"
+	require.NoError(t, checkFlagConflicts(cfg, []string{"-logcat-output", "/tmp/logcat.txt"}))
+
+	require.Error(t, checkFlagConflicts(cfg, []string{"-debug", "all"}))
+}
+
+func TestInstallPhases(t *testing.T) {
+	cfg := validConfig()
+	cfg.SDCardSize = "2048M"
+	phases, recreateAVD := installPhases(cfg, "/sdk/sdkmanager", "/sdk/avdmanager", []string{"--sdcard", "512M"}, true, nil, nil)
+
+	require.Equal(t, []string{"Installing system image package", "Creating device"}, phaseNames(phases))
+	require.Equal(t, []string{"--verbose", "--channel=0", "system-images;android-34;google_apis;x86_64"}, phases[0].args)
+	require.Equal(t, recreateAVD, phases[1])
+	require.Equal(t, []string{
+		"--verbose", "create", "avd", "--force",
+		"--name", "emulator",
+		"--device", "pixel",
+		"--package", "system-images;android-34;google_apis;x86_64",
+		"--abi", "x86_64",
+		"--tag", "google_apis",
+		"--sdcard", "512M",
+	}, recreateAVD.args)
+
+	cfg.EmulatorChannel = "1"
+	cfg.Tag = "google_apis_ps16k"
+	phases, recreateAVD = installPhases(cfg, "/sdk/sdkmanager", "/sdk/avdmanager", nil, true, nil, nil)
+
+	require.Equal(t, []string{"Updating emulator", "Installing system image package", "Creating device"}, phaseNames(phases))
+	require.Equal(t, []string{"--verbose", "--channel=1", "emulator"}, phases[0].args)
+	require.Equal(t, []string{"--verbose", "--channel=1", "system-images;android-34;google_apis_ps16k;x86_64"}, phases[1].args)
+	require.NotContains(t, recreateAVD.args, "--tag")
+	require.Equal(t, []string{"--sdcard", "2048M"}, recreateAVD.args[len(recreateAVD.args)-2:])
+	require.Contains(t, recreateAVD.args, "--force")
+
+	// Recovery recreates the AVD the step created, so only the first creation leaves out --force.
+	cfg.ReuseAVD = reuseAVDFailIfExists
+	phases, recreateAVD = installPhases(cfg, "/sdk/sdkmanager", "/sdk/avdmanager", nil, true, nil, nil)
+	require.NotContains(t, phases[2].args, "--force")
+	require.Contains(t, recreateAVD.args, "--force")
+
+	installer := &fakeSystemImageInstaller{}
+	var created int
+	phases, recreateAVD = installPhases(cfg, "/sdk/sdkmanager", "/sdk/avdmanager", nil, true, installer, func(context.Context) error {
+		created++
+		return nil
+	})
+
+	require.Equal(t, []string{"Updating emulator", "Installing system image package", "Creating device"}, phaseNames(phases))
+	require.Empty(t, phases[1].cmdName)
+	require.NoError(t, phases[1].run(context.Background()))
+	require.Equal(t, []string{"system-images;android-34;google_apis_ps16k;x86_64 channel=1"}, installer.installs)
+	require.Empty(t, recreateAVD.cmdName)
+	require.NoError(t, recreateAVD.run(context.Background()))
+	require.Equal(t, 1, created)
+
+	// A reused AVD isn't created, but boot recovery can still recreate it.
+	phases, recreateAVD = installPhases(cfg, "/sdk/sdkmanager", "/sdk/avdmanager", nil, false, nil, nil)
+	require.Equal(t, []string{"Updating emulator", "Installing system image package"}, phaseNames(phases))
+	require.Equal(t, "/sdk/avdmanager", recreateAVD.cmdName)
+}
+
+type fakeSystemImageInstaller struct {
+	installs []string
+}
+
+func (i *fakeSystemImageInstaller) Install(_ context.Context, pkgPath string, channel int) error {
+	i.installs = append(i.installs, fmt.Sprintf("%s channel=%d", pkgPath, channel))
+	return nil
+}
+
+func phaseNames(phases []phase) []string {
+	var names []string
+	for _, p := range phases {
+		names = append(names, p.name)
+	}
+	return names
+}
+
+func TestRunPhase(t *testing.T) {
+	runner := newTestRunner(NewCommandFactory(env.NewRepository()), test.NewFakeOutputExporter())
+
+	require.NoError(t, runner.runPhase(context.Background(), phase{name: "Succeeding", cmdName: "true"}))
+
+	err := runner.runPhase(context.Background(), phase{name: "Failing", cmdName: "sh", args: []string{"-c", "echo broken; exit 1"}})
+	var phaseErr PhaseError
+	require.ErrorAs(t, err, &phaseErr)
+	require.Equal(t, "Failing", phaseErr.Phase)
+	require.Equal(t, "broken", phaseErr.Output)
+
+	// The child sleep keeps the output pipe open, the phase only returns in time if the whole process group is killed.
+	err = runner.runPhase(context.Background(), phase{name: "Hanging", timeout: 100 * time.Millisecond, cmdName: "sh", args: []string{"-c", "sleep 30; true"}})
+	require.Equal(t, PhaseTimeoutError{Phase: "Hanging", Timeout: 100 * time.Millisecond}, err)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errors.New("step timed out"))
+	err = runner.runPhase(ctx, phase{name: "Interrupted", cmdName: "sleep", args: []string{"30"}})
+	require.EqualError(t, err, `phase "Interrupted" interrupted: step timed out`)
+
+	err = runner.runPhase(context.Background(), phase{name: "Failing func", run: func(context.Context) error { return errors.New("checksum mismatch") }})
+	require.Equal(t, PhaseError{Phase: "Failing func", Err: errors.New("checksum mismatch")}, err)
+
+	err = runner.runPhase(context.Background(), phase{name: "Hanging func", timeout: 10 * time.Millisecond, run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	require.Equal(t, PhaseTimeoutError{Phase: "Hanging func", Timeout: 10 * time.Millisecond}, err)
+}
+
+func TestEmulatorArgs(t *testing.T) {
+	cfg := validConfig()
+	cfg.DeployDir = "/deploy"
+	cfg.IsHeadlessMode = true
+	cfg.Locale = "fr-CA"
+	runner := newTestRunner(test.FakeCommandFactory{}, test.NewFakeOutputExporter())
+
+	logs := runner.newEmulatorLogs(cfg, nil)
+	require.Equal(t, "/deploy/emulator_20240131_090000_host.log", logs.hostLog)
+	require.Equal(t, "/deploy/emulator_20240131_090000_device_logcat.log", logs.logcat)
+
+	require.Equal(t, []string{
+		"@emulator", "-show-kernel", "-no-audio", "-no-snapshot", "-wipe-data",
+		"-netspeed", "full", "-netdelay", "none",
+		"-gpu", "auto",
+		"-no-window", "-no-boot-anim",
+		"-change-locale", "fr-CA",
+		"-debug", "init,avd,kernel,snapshot",
+		"-logcat", "*:w", "-logcat-output", "/deploy/emulator_20240131_090000_device_logcat.log",
+	}, emulatorArgs(cfg, nil, devicePreparation{}, logs, true))
+
+	// Flags set in start_command_flags take precedence, and logcat is left to the user
+	cfg.IsHeadlessMode = false
+	cfg.Locale = ""
+	cfg.HostDebugTags = "all"
+	startFlags := []string{"-gpu", "host", "-netdelay", "umts", "-logcat", "*:e"}
+	logs = runner.newEmulatorLogs(cfg, startFlags)
+	require.Empty(t, logs.logcat)
+
+	require.Equal(t, []string{
+		"@emulator", "-show-kernel", "-no-audio", "-no-snapshot", "-wipe-data",
+		"-netspeed", "full",
+		"-debug", "all",
+		"-gpu", "host", "-netdelay", "umts", "-logcat", "*:e",
+	}, emulatorArgs(cfg, startFlags, devicePreparation{}, logs, true))
+
+	// A reused AVD keeps its user data.
+	require.NotContains(t, emulatorArgs(cfg, startFlags, devicePreparation{}, logs, false), "-wipe-data")
+}
+
+func TestCleanupLogs(t *testing.T) {
+	dir := t.TempDir()
+	hostLog := filepath.Join(dir, "host.log")
+	logcat := filepath.Join(dir, "logcat.log")
+	require.NoError(t, os.WriteFile(hostLog, []byte("host"), 0644))
+	require.NoError(t, os.WriteFile(logcat, []byte("logcat"), 0644))
+
+	runner := newTestRunner(test.FakeCommandFactory{}, test.NewFakeOutputExporter())
+	logs := runner.cleanupLogs(emulatorLogs{hostLog: hostLog, logcat: logcat, keepLogcat: true})
+
+	require.Empty(t, logs.hostLog)
+	require.NoFileExists(t, hostLog)
+	require.Equal(t, logcat, logs.logcat)
+	require.FileExists(t, logcat)
+}
+
+func TestExportOutputs(t *testing.T) {
+	exporter := test.NewFakeOutputExporter()
+	newTestRunner(test.FakeCommandFactory{}, exporter).exportOutputs([]stepOutput{
+		{"BITRISE_EMULATOR_SERIAL", "emulator-5554"},
+		{"BITRISE_EMULATOR_HOST_LOG", ""},
+	})
+
+	require.Equal(t, map[string]string{"BITRISE_EMULATOR_SERIAL": "emulator-5554"}, exporter.Outputs)
+}
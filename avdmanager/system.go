@@ -0,0 +1,87 @@
+package avdmanager
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/tools"
+)
+
+// Clock is the time source of the boot flow: phase durations, log file names, boot timeouts and polling.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+// NewClock returns the system clock.
+func NewClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// FileSystem is the file access of the step outside the Android SDK: logs, generated scripts and temporary files.
+type FileSystem interface {
+	Create(name string) (io.WriteCloser, error)
+	Remove(name string) error
+	RemoveAll(path string) error
+	Stat(name string) (os.FileInfo, error)
+	MkdirTemp(dir, pattern string) (string, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+}
+
+type osFileSystem struct{}
+
+// NewFileSystem returns the file system of the host.
+func NewFileSystem() FileSystem {
+	return osFileSystem{}
+}
+
+func (osFileSystem) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) MkdirTemp(dir, pattern string) (string, error) {
+	return os.MkdirTemp(dir, pattern)
+}
+
+func (osFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+// OutputExporter exports the step outputs.
+type OutputExporter interface {
+	ExportOutput(key, value string) error
+}
+
+type envmanExporter struct{}
+
+// NewOutputExporter returns an exporter making the outputs available to the next steps with envman.
+func NewOutputExporter() OutputExporter {
+	return envmanExporter{}
+}
+
+func (envmanExporter) ExportOutput(key, value string) error {
+	return tools.ExportEnvironmentWithEnvman(key, value)
+}
@@ -0,0 +1,82 @@
+// Package cacerts prepares CA certificates for the Android system trust store.
+package cacerts
+
+import (
+	"crypto/md5"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// SystemStoreDir is the system trust store of the device, up to API 33. From API 34 the trust store is
+// part of the Conscrypt APEX module, which can't be remounted writable.
+const SystemStoreDir = "/system/etc/security/cacerts"
+
+// Certificate is a CA certificate and its file name in the system trust store.
+type Certificate struct {
+	Path     string
+	FileName string
+	Cert     *x509.Certificate
+}
+
+// PEM returns the certificate in the PEM format expected by the trust store.
+func (c Certificate) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// Load reads the PEM or DER encoded CA certificates, and names them the way the trust store looks them up:
+// <subject hash>.<n>, where n distinguishes certificates with the same subject hash.
+func Load(paths []string) ([]Certificate, error) {
+	var certs []Certificate
+	used := map[string]bool{}
+	for _, path := range paths {
+		cert, err := load(path)
+		if err != nil {
+			return nil, err
+		}
+		if !cert.IsCA {
+			return nil, fmt.Errorf("%s is not a CA certificate", path)
+		}
+
+		hash := SubjectHashOld(cert)
+		fileName := ""
+		for n := 0; fileName == "" || used[fileName]; n++ {
+			fileName = fmt.Sprintf("%s.%d", hash, n)
+		}
+		used[fileName] = true
+
+		certs = append(certs, Certificate{Path: path, FileName: fileName, Cert: cert})
+	}
+	return certs, nil
+}
+
+func load(path string) (*x509.Certificate, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	der := content
+	if block, _ := pem.Decode(content); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%s: unexpected PEM block %s, expected CERTIFICATE", path, block.Type)
+		}
+		der = block.Bytes
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cert, nil
+}
+
+// SubjectHashOld returns the subject hash Android uses to name trust store files,
+// the same as `openssl x509 -subject_hash_old`: the first 4 bytes of the MD5 digest of the DER encoded subject,
+// as a little-endian hex number.
+func SubjectHashOld(cert *x509.Certificate) string {
+	digest := md5.Sum(cert.RawSubject)
+	return fmt.Sprintf("%08x", binary.LittleEndian.Uint32(digest[:4]))
+}
@@ -0,0 +1,99 @@
+package cacerts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Generated with: openssl req -x509 -subj "/C=US/O=Bitrise Test/CN=Bitrise Test CA" -addext "basicConstraints=critical,CA:TRUE"
+// openssl x509 -noout -subject_hash_old: f5913e33
+const testCAPEM = `-----BEGIN CERTIFICATE-----
+MIIDXTCCAkWgAwIBAgIUJ0udR9G1SQL0QX/poMae/PxAdz0wDQYJKoZIhvcNAQEL
+BQAwPjELMAkGA1UEBhMCVVMxFTATBgNVBAoMDEJpdHJpc2UgVGVzdDEYMBYGA1UE
+AwwPQml0cmlzZSBUZXN0IENBMB4XDTI2MTAxODIxMTMwMVoXDTM2MTAxNTIxMTMw
+MVowPjELMAkGA1UEBhMCVVMxFTATBgNVBAoMDEJpdHJpc2UgVGVzdDEYMBYGA1UE
+AwwPQml0cmlzZSBUZXN0IENBMIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKC
+AQEAnPwYILSxUQI0onBX9iSr4y6tW+IOwIAghDBZiQH2KtxS2egdyrCVRccREj4n
+E5qvG/jeMejrfaxXBQRP7m2Lq8x5+Ehm06Kxe9tN0A+eBbKGDJRGOIAtCPEiL5gJ
+mjXfz1JtM6EEPUH5HSn4AUlQ/IvkdSsvdqPbs7I2+gQRjTU7BMZWWSC4pV+LIl8z
+OBXJ77LKp8dS8t5Bqnxhee7jdvKtL9psXXJL6cvGoTSIYhTQbxwnVQONrcHLSgAZ
+aVGbNfWI3EMmc6DZD4SvmoglTGV+vL2Mfu5d587z0W9bJVKPoYE0loFII7Hy8j2K
+9bOUNV8m3ADFWhvqAquDbmqU4QIDAQABo1MwUTAdBgNVHQ4EFgQUGEYabCFrUWDo
+SSmrt0fj7C29m2swHwYDVR0jBBgwFoAUGEYabCFrUWDoSSmrt0fj7C29m2swDwYD
+VR0TAQH/BAUwAwEB/zANBgkqhkiG9w0BAQsFAAOCAQEAAj9k+dlshpFna37wUEQY
+ETxubrZ1wpDN/Zy3yCChYEakUX1roSFjOan+WZV8RDNvsP4gi1fwMzkqHL0nFbsZ
+VUmNuY/UkKZbb49bawah3M1yoYWH1DCAfFPoAxs5nrKPlwOx5ObXITmsQEzSfkGQ
+NkNZH8TBdv6JofRAFxJit7pVRmnCSMGBNO6ib0570IhGInx+x5W+fytOLFStrpmE
+6faucKrJ0rNT+9p5x1BZGOCjjoWH5zCAHhpvfg2vXZz/BuPnJuui/8ch27zEw9ld
+H0Py6AyAgh+4B8YzH1j3F+ij1tvcMzZ0cjVLDO//cf6VJI6jaBfK0Wy/M/KzuOhY
+PQ==
+-----END CERTIFICATE-----
+`
+
+func TestSubjectHashOld(t *testing.T) {
+	block, _ := pem.Decode([]byte(testCAPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	require.Equal(t, "f5913e33", SubjectHashOld(cert))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	block, _ := pem.Decode([]byte(testCAPEM))
+
+	pemPath := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(pemPath, []byte(testCAPEM), 0644))
+	derPath := filepath.Join(dir, "ca.der")
+	require.NoError(t, os.WriteFile(derPath, block.Bytes, 0644))
+
+	certs, err := Load([]string{pemPath, derPath})
+	require.NoError(t, err)
+	require.Len(t, certs, 2)
+	require.Equal(t, "f5913e33.0", certs[0].FileName)
+	require.Equal(t, "f5913e33.1", certs[1].FileName)
+	require.Equal(t, testCAPEM, string(certs[1].PEM()))
+}
+
+func TestLoad_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	leafPath := filepath.Join(dir, "leaf.pem")
+	require.NoError(t, os.WriteFile(leafPath, leafCertificatePEM(t), 0644))
+	_, err := Load([]string{leafPath})
+	require.EqualError(t, err, leafPath+" is not a CA certificate")
+
+	keyPath := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{0}}), 0644))
+	_, err = Load([]string{keyPath})
+	require.EqualError(t, err, keyPath+": unexpected PEM block PRIVATE KEY, expected CERTIFICATE")
+
+	_, err = Load([]string{filepath.Join(dir, "missing.pem")})
+	require.Error(t, err)
+}
+
+func leafCertificatePEM(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "leaf.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,194 @@
+package deviceprep
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"gopkg.in/yaml.v3"
+)
+
+type ItemType string
+
+const (
+	ItemTypeSetting  ItemType = "setting"
+	ItemTypeProperty ItemType = "property"
+	ItemTypeShell    ItemType = "shell"
+)
+
+// ErrorPolicy defines what happens when applying or verifying an item fails.
+type ErrorPolicy string
+
+const (
+	OnErrorFail   ErrorPolicy = "fail"
+	OnErrorWarn   ErrorPolicy = "warn"
+	OnErrorIgnore ErrorPolicy = "ignore"
+)
+
+// Item is a single device preparation step: a settings value (`settings put`), a system property (`setprop`)
+// or an arbitrary shell command.
+type Item struct {
+	Type ItemType `yaml:"type"`
+	// Namespace is the settings namespace: global, secure or system.
+	Namespace string      `yaml:"namespace,omitempty"`
+	Key       string      `yaml:"key,omitempty"`
+	Value     string      `yaml:"value,omitempty"`
+	Command   string      `yaml:"command,omitempty"`
+	OnError   ErrorPolicy `yaml:"on_error,omitempty"`
+}
+
+func (i Item) String() string {
+	switch i.Type {
+	case ItemTypeSetting:
+		return fmt.Sprintf("settings put %s %s %s", i.Namespace, i.Key, i.Value)
+	case ItemTypeProperty:
+		return fmt.Sprintf("setprop %s %s", i.Key, i.Value)
+	default:
+		return i.Command
+	}
+}
+
+func (i Item) validate() error {
+	switch i.Type {
+	case ItemTypeSetting:
+		switch i.Namespace {
+		case "global", "secure", "system":
+		default:
+			return fmt.Errorf("invalid settings namespace %q, expected global, secure or system", i.Namespace)
+		}
+		if i.Key == "" {
+			return errors.New("setting key is empty")
+		}
+	case ItemTypeProperty:
+		if i.Key == "" {
+			return errors.New("property key is empty")
+		}
+	case ItemTypeShell:
+		if strings.TrimSpace(i.Command) == "" {
+			return errors.New("shell command is empty")
+		}
+	default:
+		return fmt.Errorf("invalid item type %q, expected setting, property or shell", i.Type)
+	}
+
+	switch i.OnError {
+	case "", OnErrorFail, OnErrorWarn, OnErrorIgnore:
+	default:
+		return fmt.Errorf("invalid on_error %q, expected fail, warn or ignore", i.OnError)
+	}
+	return nil
+}
+
+// Parse parses a YAML (or JSON) list of items. Items without an error policy get the fail policy.
+func Parse(content string) ([]Item, error) {
+	var items []Item
+	if err := yaml.Unmarshal([]byte(content), &items); err != nil {
+		return nil, fmt.Errorf("parse device settings: %w", err)
+	}
+
+	for idx := range items {
+		if err := items[idx].validate(); err != nil {
+			return nil, fmt.Errorf("device settings item %d: %w", idx+1, err)
+		}
+		if items[idx].OnError == "" {
+			items[idx].OnError = OnErrorFail
+		}
+	}
+	return items, nil
+}
+
+// Load parses the items from the input, which is either inline YAML/JSON or a path to a file containing it.
+func Load(input string) ([]Item, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, nil
+	}
+
+	if info, err := os.Stat(input); err == nil && !info.IsDir() {
+		content, err := os.ReadFile(input)
+		if err != nil {
+			return nil, fmt.Errorf("read device settings file: %w", err)
+		}
+		return Parse(string(content))
+	}
+	return Parse(input)
+}
+
+// Shell runs commands in the device shell.
+type Shell interface {
+	Shell(serial string, args ...string) (string, error)
+}
+
+type Result struct {
+	Item Item
+	Err  error
+}
+
+// Preparer applies device preparation items over adb.
+type Preparer struct {
+	shell  Shell
+	logger log.Logger
+}
+
+func NewPreparer(shell Shell, logger log.Logger) Preparer {
+	return Preparer{shell: shell, logger: logger}
+}
+
+// Apply applies and verifies the items in order. It stops at the first failing item with the fail policy,
+// other failures are only reported in the results.
+func (p Preparer) Apply(serial string, items []Item) ([]Result, error) {
+	var results []Result
+	for _, item := range items {
+		err := p.apply(serial, item)
+		results = append(results, Result{Item: item, Err: err})
+
+		switch {
+		case err == nil:
+			p.logger.Printf("✓ %s", item)
+		case item.OnError == OnErrorIgnore:
+			p.logger.Printf("- %s (ignored: %s)", item, err)
+		case item.OnError == OnErrorWarn:
+			p.logger.Warnf("✗ %s: %s", item, err)
+		default:
+			p.logger.Errorf("✗ %s: %s", item, err)
+			return results, fmt.Errorf("%s: %w", item, err)
+		}
+	}
+	return results, nil
+}
+
+func (p Preparer) apply(serial string, item Item) error {
+	switch item.Type {
+	case ItemTypeSetting:
+		if _, err := p.shell.Shell(serial, "settings", "put", item.Namespace, item.Key, item.Value); err != nil {
+			return err
+		}
+		actual, err := p.shell.Shell(serial, "settings", "get", item.Namespace, item.Key)
+		if err != nil {
+			return err
+		}
+		return verify(item.Value, actual)
+	case ItemTypeProperty:
+		if _, err := p.shell.Shell(serial, "setprop", item.Key, item.Value); err != nil {
+			return err
+		}
+		actual, err := p.shell.Shell(serial, "getprop", item.Key)
+		if err != nil {
+			return err
+		}
+		return verify(item.Value, actual)
+	case ItemTypeShell:
+		_, err := p.shell.Shell(serial, item.Command)
+		return err
+	default:
+		return fmt.Errorf("invalid item type %q", item.Type)
+	}
+}
+
+func verify(expected, actual string) error {
+	if strings.TrimSpace(actual) != expected {
+		return fmt.Errorf("verification failed: expected %q, got %q", expected, strings.TrimSpace(actual))
+	}
+	return nil
+}
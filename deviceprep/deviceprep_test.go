@@ -0,0 +1,189 @@
+package deviceprep
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeShell stores written settings and properties, so that reading them back returns the written value.
+type fakeShell struct {
+	values   map[string]string
+	failing  map[string]bool
+	commands []string
+}
+
+func newFakeShell() *fakeShell {
+	return &fakeShell{values: map[string]string{}, failing: map[string]bool{}}
+}
+
+func (s *fakeShell) Shell(_ string, args ...string) (string, error) {
+	command := strings.Join(args, " ")
+	s.commands = append(s.commands, command)
+	if s.failing[command] {
+		return "", errors.New("exit status 1")
+	}
+
+	switch {
+	case len(args) == 5 && args[0] == "settings" && args[1] == "put":
+		s.values[args[2]+"/"+args[3]] = args[4]
+	case len(args) == 4 && args[0] == "settings" && args[1] == "get":
+		if value, ok := s.values[args[2]+"/"+args[3]]; ok {
+			return value, nil
+		}
+		return "null", nil
+	case len(args) == 3 && args[0] == "setprop":
+		s.values[args[1]] = args[2]
+	case len(args) == 2 && args[0] == "getprop":
+		return s.values[args[1]], nil
+	}
+	return "", nil
+}
+
+func TestParse(t *testing.T) {
+	items, err := Parse(`
+- type: setting
+  namespace: global
+  key: window_animation_scale
+  value: 0
+- type: property
+  key: debug.hwui.renderer
+  value: skiagl
+  on_error: warn
+- type: shell
+  command: input keyevent 82
+  on_error: ignore
+`)
+	require.NoError(t, err)
+	require.Equal(t, []Item{
+		{Type: ItemTypeSetting, Namespace: "global", Key: "window_animation_scale", Value: "0", OnError: OnErrorFail},
+		{Type: ItemTypeProperty, Key: "debug.hwui.renderer", Value: "skiagl", OnError: OnErrorWarn},
+		{Type: ItemTypeShell, Command: "input keyevent 82", OnError: OnErrorIgnore},
+	}, items)
+
+	items, err = Parse(`[{"type": "setting", "namespace": "secure", "key": "show_ime_with_hard_keyboard", "value": 0}]`)
+	require.NoError(t, err)
+	require.Equal(t, []Item{
+		{Type: ItemTypeSetting, Namespace: "secure", Key: "show_ime_with_hard_keyboard", Value: "0", OnError: OnErrorFail},
+	}, items)
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unknown type",
+			content: `[{"type": "file"}]`,
+			wantErr: "invalid item type",
+		},
+		{
+			name:    "unknown namespace",
+			content: `[{"type": "setting", "namespace": "local", "key": "a", "value": "b"}]`,
+			wantErr: "invalid settings namespace",
+		},
+		{
+			name:    "unknown error policy",
+			content: `[{"type": "shell", "command": "true", "on_error": "retry"}]`,
+			wantErr: "invalid on_error",
+		},
+		{
+			name:    "not a list",
+			content: `type: shell`,
+			wantErr: "parse device settings",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.content)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoadFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.yml")
+	require.NoError(t, os.WriteFile(path, []byte("- type: shell\n  command: input keyevent 82\n"), 0644))
+
+	items, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+}
+
+func TestApply(t *testing.T) {
+	shell := newFakeShell()
+	preparer := NewPreparer(shell, log.NewLogger())
+
+	items, err := Preset("ui-testing")
+	require.NoError(t, err)
+	items = append(items, Item{Type: ItemTypeShell, Command: "input keyevent 82", OnError: OnErrorFail})
+
+	results, err := preparer.Apply("emulator-5554", items)
+	require.NoError(t, err)
+	require.Len(t, results, len(items))
+	for _, result := range results {
+		assert.NoError(t, result.Err, result.Item.String())
+	}
+	assert.Equal(t, "0", shell.values["global/window_animation_scale"])
+	assert.Equal(t, "confirmed", shell.values["secure/immersive_mode_confirmations"])
+	assert.Equal(t, "input keyevent 82", shell.commands[len(shell.commands)-1])
+}
+
+func TestApplyErrorPolicies(t *testing.T) {
+	shell := newFakeShell()
+	shell.failing["setprop persist.sys.a 1"] = true
+	shell.failing["input keyevent 82"] = true
+	preparer := NewPreparer(shell, log.NewLogger())
+
+	results, err := preparer.Apply("emulator-5554", []Item{
+		{Type: ItemTypeProperty, Key: "persist.sys.a", Value: "1", OnError: OnErrorWarn},
+		{Type: ItemTypeShell, Command: "input keyevent 82", OnError: OnErrorIgnore},
+		{Type: ItemTypeProperty, Key: "persist.sys.b", Value: "1", OnError: OnErrorFail},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Error(t, results[0].Err)
+	assert.Error(t, results[1].Err)
+	assert.NoError(t, results[2].Err)
+
+	shell.failing["setprop persist.sys.b 1"] = true
+	results, err = preparer.Apply("emulator-5554", []Item{
+		{Type: ItemTypeProperty, Key: "persist.sys.b", Value: "1", OnError: OnErrorFail},
+		{Type: ItemTypeShell, Command: "true", OnError: OnErrorFail},
+	})
+	require.Error(t, err)
+	require.Len(t, results, 1)
+}
+
+func TestApplyVerificationFailure(t *testing.T) {
+	preparer := NewPreparer(readOnlyShell{}, log.NewLogger())
+
+	_, err := preparer.Apply("emulator-5554", []Item{
+		{Type: ItemTypeSetting, Namespace: "global", Key: "hide_error_dialogs", Value: "1", OnError: OnErrorFail},
+	})
+	require.ErrorContains(t, err, `verification failed: expected "1", got "0"`)
+}
+
+type readOnlyShell struct{}
+
+func (readOnlyShell) Shell(string, ...string) (string, error) {
+	return "0", nil
+}
+
+func TestPreset(t *testing.T) {
+	items, err := Preset(PresetNone)
+	require.NoError(t, err)
+	require.Empty(t, items)
+
+	_, err = Preset("unknown")
+	require.ErrorContains(t, err, "ui-testing")
+}
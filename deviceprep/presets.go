@@ -0,0 +1,51 @@
+package deviceprep
+
+import (
+	"fmt"
+	"sort"
+)
+
+const PresetNone = "none"
+
+// AnimationsOff turns off window, transition and animator animations.
+var AnimationsOff = []Item{
+	{Type: ItemTypeSetting, Namespace: "global", Key: "window_animation_scale", Value: "0", OnError: OnErrorWarn},
+	{Type: ItemTypeSetting, Namespace: "global", Key: "transition_animation_scale", Value: "0", OnError: OnErrorWarn},
+	{Type: ItemTypeSetting, Namespace: "global", Key: "animator_duration_scale", Value: "0", OnError: OnErrorWarn},
+}
+
+var presets = map[string][]Item{
+	"ui-testing": append(append([]Item{}, AnimationsOff...),
+		// Keep the screen on while charging (AC, USB and wireless)
+		Item{Type: ItemTypeSetting, Namespace: "global", Key: "stay_on_while_plugged_in", Value: "7", OnError: OnErrorWarn},
+		// Disable the spell checker behind soft keyboard autocorrect
+		Item{Type: ItemTypeSetting, Namespace: "secure", Key: "spell_checker_enabled", Value: "0", OnError: OnErrorWarn},
+		// Hide "App isn't responding" and crash dialogs
+		Item{Type: ItemTypeSetting, Namespace: "global", Key: "hide_error_dialogs", Value: "1", OnError: OnErrorWarn},
+		// Skip the "Viewing full screen" confirmation
+		Item{Type: ItemTypeSetting, Namespace: "secure", Key: "immersive_mode_confirmations", Value: "confirmed", OnError: OnErrorWarn},
+	),
+}
+
+// Preset returns the items of a built-in preset.
+func Preset(name string) ([]Item, error) {
+	if name == "" || name == PresetNone {
+		return nil, nil
+	}
+
+	items, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown device settings preset %q, available presets: %v", name, PresetNames())
+	}
+	return append([]Item{}, items...), nil
+}
+
+// PresetNames returns the names of the built-in presets.
+func PresetNames() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,259 @@
+// Package devices reads the device definitions (hardware profiles) AVDs are created from: the common definitions
+// bundled with avdmanager, the ones shipped with the installed system images and the user's own in
+// ~/.android/devices.xml, all in the devices.xml format of the Android SDK.
+package devices
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+//go:embed builtin.xml
+var builtinXML []byte
+
+const builtinSource = "built-in"
+
+// Device is a device definition.
+type Device struct {
+	ID           string
+	Name         string
+	Manufacturer string
+	// TagID is the system image tag the device is meant for, e.g. android-tv, empty for phones and tablets.
+	TagID string
+	// Source is the devices.xml file defining the device, or built-in.
+	Source string
+
+	ScreenWidth    int
+	ScreenHeight   int
+	Density        int
+	DiagonalInches float64
+	RAMMegabytes   int
+
+	Keyboard        bool
+	DPad            bool
+	TrackBall       bool
+	HardwareButtons bool
+
+	Accelerometer   bool
+	Gyroscope       bool
+	ProximitySensor bool
+	GPS             bool
+	Microphone      bool
+	Battery         bool
+	BackCamera      bool
+	FrontCamera     bool
+}
+
+// Specs returns the key specs of the device for the log, e.g. 1080x1920 px, 420 dpi, 5.0", 2048 MB RAM.
+func (d Device) Specs() string {
+	specs := []string{fmt.Sprintf("%dx%d px", d.ScreenWidth, d.ScreenHeight), fmt.Sprintf("%d dpi", d.Density)}
+	if d.DiagonalInches > 0 {
+		specs = append(specs, fmt.Sprintf("%.1f\"", d.DiagonalInches))
+	}
+	if d.RAMMegabytes > 0 {
+		specs = append(specs, fmt.Sprintf("%d MB RAM", d.RAMMegabytes))
+	}
+	if d.Keyboard {
+		specs = append(specs, "keyboard")
+	}
+	if d.DPad {
+		specs = append(specs, "D-pad")
+	}
+	if d.HardwareButtons {
+		specs = append(specs, "hardware buttons")
+	}
+	return strings.Join(specs, ", ")
+}
+
+// Catalog is the set of the known device definitions, by ID.
+type Catalog struct {
+	devices map[string]Device
+}
+
+// NewCatalog returns a Catalog of the given definitions, a later definition replaces an earlier one with the same ID.
+func NewCatalog(devices ...Device) Catalog {
+	c := Catalog{devices: map[string]Device{}}
+	for _, device := range devices {
+		c.devices[device.ID] = device
+	}
+	return c
+}
+
+// Builtin returns the common device definitions bundled with avdmanager.
+func Builtin() ([]Device, error) {
+	return Parse(bytes.NewReader(builtinXML), builtinSource)
+}
+
+// Load returns the built-in definitions, overridden by the definitions of the system images installed in
+// androidHome, then by the user's definitions. Files which can't be read are skipped with a warning,
+// a broken definition shouldn't stop the step from using the other ones.
+func Load(androidHome string, envRepo env.Repository, logger log.Logger) (Catalog, error) {
+	devices, err := Builtin()
+	if err != nil {
+		return Catalog{}, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(androidHome, "system-images", "*", "*", "*", "devices.xml"))
+	if err != nil {
+		logger.Warnf("Failed to list the device definitions of the system images: %s", err)
+	}
+	sort.Strings(paths)
+	if userHome, err := UserHomeDir(envRepo); err != nil {
+		logger.Warnf("Failed to locate the user's device definitions: %s", err)
+	} else {
+		paths = append(paths, filepath.Join(userHome, "devices.xml"))
+	}
+
+	for _, pth := range paths {
+		fileDevices, err := parseFile(pth)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			logger.Warnf("Skipping device definitions: %s", err)
+			continue
+		}
+		devices = append(devices, fileDevices...)
+	}
+	return NewCatalog(devices...), nil
+}
+
+func parseFile(pth string) ([]Device, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, pth)
+}
+
+// UserHomeDir returns the Android user home holding the user's devices.xml, e.g. ~/.android.
+func UserHomeDir(envRepo env.Repository) (string, error) {
+	if userHome := envRepo.Get("ANDROID_USER_HOME"); userHome != "" {
+		return userHome, nil
+	}
+	if sdkHome := envRepo.Get("ANDROID_SDK_HOME"); sdkHome != "" {
+		return filepath.Join(sdkHome, ".android"), nil
+	}
+
+	home := envRepo.Get("HOME")
+	if home == "" {
+		var err error
+		home, err = os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("resolve home directory: %w", err)
+		}
+	}
+	return filepath.Join(home, ".android"), nil
+}
+
+// IDs returns the IDs of the known devices, sorted.
+func (c Catalog) IDs() []string {
+	var ids []string
+	for id := range c.devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Lookup returns the device with the given ID or name, e.g. pixel_6 or Pixel 6. IDs and names are compared
+// case-insensitively, spaces, dashes and underscores are the same, and IDs take precedence over names.
+// The error of an unknown profile suggests the closest IDs.
+func (c Catalog) Lookup(profile string) (Device, error) {
+	if device, ok := c.devices[profile]; ok {
+		return device, nil
+	}
+
+	query := normalize(profile)
+	for _, field := range []func(Device) string{
+		func(d Device) string { return d.ID },
+		func(d Device) string { return d.Name },
+	} {
+		var matches []string
+		for _, id := range c.IDs() {
+			if normalize(field(c.devices[id])) == query {
+				matches = append(matches, id)
+			}
+		}
+		if len(matches) == 1 {
+			return c.devices[matches[0]], nil
+		}
+		if len(matches) > 1 {
+			return Device{}, fmt.Errorf("ambiguous device profile: %s, matching profiles: %s", profile, strings.Join(matches, ", "))
+		}
+	}
+
+	if suggestions := c.Suggest(profile); len(suggestions) > 0 {
+		return Device{}, fmt.Errorf("unknown device profile: %s, did you mean: %s?", profile, strings.Join(suggestions, ", "))
+	}
+	return Device{}, fmt.Errorf("unknown device profile: %s, available profiles: %s", profile, strings.Join(c.IDs(), ", "))
+}
+
+// maxSuggestions is the number of the closest device IDs suggested for an unknown ID.
+const maxSuggestions = 3
+
+// Suggest returns the IDs of the devices whose ID or name is close to the given ID, the closest first.
+func (c Catalog) Suggest(id string) []string {
+	query := normalize(id)
+	maxDistance := max(2, len(query)/3)
+
+	type suggestion struct {
+		id       string
+		distance int
+	}
+	var suggestions []suggestion
+	for _, device := range c.devices {
+		distance := min(levenshtein(query, normalize(device.ID)), levenshtein(query, normalize(device.Name)))
+		if distance <= maxDistance {
+			suggestions = append(suggestions, suggestion{device.ID, distance})
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		return suggestions[i].id < suggestions[j].id
+	})
+
+	var ids []string
+	for i := 0; i < len(suggestions) && i < maxSuggestions; i++ {
+		ids = append(ids, suggestions[i].id)
+	}
+	return ids
+}
+
+// normalize makes the IDs and names comparable: Pixel 2 XL and pixel_2_xl are the same.
+func normalize(s string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(s)))
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
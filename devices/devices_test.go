@@ -0,0 +1,192 @@
+package devices
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func builtinCatalog(t *testing.T) Catalog {
+	builtin, err := Builtin()
+	require.NoError(t, err)
+	return NewCatalog(builtin...)
+}
+
+func TestParse(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "devices.xml"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	devices, err := Parse(f, "devices.xml")
+
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+	require.Equal(t, Device{
+		ID:              "kiosk",
+		Name:            "Kiosk",
+		Manufacturer:    "User",
+		Source:          "devices.xml",
+		ScreenWidth:     1280,
+		ScreenHeight:    800,
+		Density:         240,
+		DiagonalInches:  10.1,
+		RAMMegabytes:    1536,
+		Keyboard:        true,
+		DPad:            true,
+		HardwareButtons: true,
+		Accelerometer:   true,
+		GPS:             true,
+		FrontCamera:     true,
+	}, devices[0])
+	require.Equal(t, `1280x800 px, 240 dpi, 10.1", 1536 MB RAM, keyboard, D-pad, hardware buttons`, devices[0].Specs())
+	require.Equal(t, 4096, devices[1].RAMMegabytes)
+	require.Equal(t, 480, devices[1].Density)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		wantErr string
+	}{
+		{name: "malformed", xml: `<d:devices><d:device>`, wantErr: "parse devices.xml: XML syntax error"},
+		{name: "missing id", xml: `<devices><device><name>Phone</name></device></devices>`, wantErr: `device "Phone" has no id`},
+		{name: "invalid density", xml: `<devices><device><id>phone</id><hardware><screen><pixel-density>huge</pixel-density></screen></hardware></device></devices>`, wantErr: `device phone: invalid pixel density: "huge"`},
+		{name: "invalid RAM unit", xml: `<devices><device><id>phone</id><hardware><screen><pixel-density>420dpi</pixel-density></screen><ram unit="GB">2</ram></hardware></device></devices>`, wantErr: `device phone: invalid RAM unit: "GB"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.xml), "devices.xml")
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	catalog := builtinCatalog(t)
+
+	pixel, err := catalog.Lookup("pixel")
+	require.NoError(t, err)
+	require.Equal(t, `1080x1920 px, 420 dpi, 5.0", 2048 MB RAM`, pixel.Specs())
+
+	tv, err := catalog.Lookup("tv_1080p")
+	require.NoError(t, err)
+	require.Equal(t, "android-tv", tv.TagID)
+	require.True(t, tv.DPad)
+	require.False(t, tv.Battery)
+
+	for _, id := range []string{"pixel_7_pro", "pixel_8_pro", "pixel_9", "Nexus 5", "medium_tablet", "small_phone", "wearos_large_round", "automotive_1024p_landscape"} {
+		require.Contains(t, catalog.IDs(), id)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	androidHome := t.TempDir()
+	userHome := t.TempDir()
+
+	wearDir := filepath.Join(androidHome, "system-images", "android-30", "android-wear", "x86")
+	require.NoError(t, os.MkdirAll(wearDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(wearDir, "devices.xml"), []byte(
+		`<d:devices xmlns:d="http://schemas.android.com/sdk/devices/3"><d:device><d:name>Wear OS Small Round</d:name><d:id>wearos_small_round</d:id>`+
+			`<d:hardware><d:screen><d:pixel-density>hdpi</d:pixel-density><d:dimensions><d:x-dimension>384</d:x-dimension><d:y-dimension>384</d:y-dimension></d:dimensions></d:screen></d:hardware>`+
+			`<d:tag-id>android-wear</d:tag-id></d:device></d:devices>`), 0644))
+	brokenDir := filepath.Join(androidHome, "system-images", "android-30", "android-tv", "x86")
+	require.NoError(t, os.MkdirAll(brokenDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(brokenDir, "devices.xml"), []byte("<d:devices>"), 0644))
+
+	userDevices, err := os.ReadFile(filepath.Join("testdata", "devices.xml"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(userHome, "devices.xml"), userDevices, 0644))
+
+	t.Setenv("ANDROID_USER_HOME", userHome)
+	catalog, err := Load(androidHome, env.NewRepository(), log.NewLogger())
+	require.NoError(t, err)
+
+	wear, err := catalog.Lookup("wearos_small_round")
+	require.NoError(t, err)
+	require.Equal(t, "android-wear", wear.TagID)
+
+	// The user's definitions override the built-in ones.
+	pixel, err := catalog.Lookup("pixel")
+	require.NoError(t, err)
+	require.Equal(t, "Pixel (custom)", pixel.Name)
+	require.Equal(t, filepath.Join(userHome, "devices.xml"), pixel.Source)
+
+	require.Contains(t, catalog.IDs(), "kiosk")
+	require.Contains(t, catalog.IDs(), "pixel_2")
+}
+
+func TestLookup(t *testing.T) {
+	catalog := builtinCatalog(t)
+
+	tests := []struct {
+		profile string
+		wantID  string
+	}{
+		{profile: "pixel_7_pro", wantID: "pixel_7_pro"},
+		{profile: "Pixel 6", wantID: "pixel_6"},
+		{profile: "PIXEL-9-PRO-XL", wantID: "pixel_9_pro_xl"},
+		{profile: "Nexus 5X", wantID: "Nexus 5X"},
+		{profile: "nexus_5x", wantID: "Nexus 5X"},
+		{profile: "Wear OS Small Round", wantID: "wearos_small_round"},
+		// The ID of the 2012 model takes precedence over the name of the 2013 one.
+		{profile: "nexus 7", wantID: "Nexus 7"},
+		{profile: "Nexus 7 (2012)", wantID: "Nexus 7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			device, err := catalog.Lookup(tt.profile)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantID, device.ID)
+		})
+	}
+
+	_, err := NewCatalog(Device{ID: "kiosk_1", Name: "Kiosk"}, Device{ID: "kiosk_2", Name: "Kiosk"}).Lookup("kiosk")
+	require.EqualError(t, err, "ambiguous device profile: kiosk, matching profiles: kiosk_1, kiosk_2")
+}
+
+func TestLookup_Suggestions(t *testing.T) {
+	catalog := builtinCatalog(t)
+
+	tests := []struct {
+		profile string
+		wantErr string
+	}{
+		{profile: "pixle", wantErr: "unknown device profile: pixle, did you mean: pixel?"},
+		{profile: "pixel_7_pr", wantErr: "unknown device profile: pixel_7_pr, did you mean: pixel_7_pro, pixel_6_pro, pixel_8_pro?"},
+		{profile: "nexus5", wantErr: "unknown device profile: nexus5, did you mean: Nexus 5, Nexus 4, Nexus 5X?"},
+		{profile: "Televison (4K)", wantErr: "did you mean: tv_4k?"},
+		{profile: "galaxy", wantErr: "unknown device profile: galaxy, available profiles: 10.1in WXGA (Tablet), 13.5in Freeform,"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			_, err := catalog.Lookup(tt.profile)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestUserHomeDir(t *testing.T) {
+	tests := []struct {
+		envs map[string]string
+		want string
+	}{
+		{envs: map[string]string{"ANDROID_USER_HOME": "/user", "ANDROID_SDK_HOME": "/sdk"}, want: "/user"},
+		{envs: map[string]string{"ANDROID_SDK_HOME": "/sdk", "HOME": "/home/user"}, want: "/sdk/.android"},
+		{envs: map[string]string{"HOME": "/home/user"}, want: "/home/user/.android"},
+	}
+	for _, tt := range tests {
+		for _, key := range []string{"ANDROID_USER_HOME", "ANDROID_SDK_HOME", "HOME"} {
+			t.Setenv(key, tt.envs[key])
+		}
+
+		got, err := UserHomeDir(env.NewRepository())
+		require.NoError(t, err)
+		require.Equal(t, tt.want, got)
+	}
+}
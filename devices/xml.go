@@ -0,0 +1,176 @@
+package devices
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The elements of the devices.xml schema (http://schemas.android.com/sdk/devices/N) read by the step,
+// the namespace prefix is ignored so every schema version is accepted.
+type devicesXML struct {
+	Devices []deviceXML `xml:"device"`
+}
+
+type deviceXML struct {
+	Name         string      `xml:"name"`
+	ID           string      `xml:"id"`
+	Manufacturer string      `xml:"manufacturer"`
+	Hardware     hardwareXML `xml:"hardware"`
+	TagID        string      `xml:"tag-id"`
+}
+
+type hardwareXML struct {
+	Screen    screenXML   `xml:"screen"`
+	Sensors   string      `xml:"sensors"`
+	Mic       bool        `xml:"mic"`
+	Cameras   []cameraXML `xml:"camera"`
+	Keyboard  string      `xml:"keyboard"`
+	Nav       string      `xml:"nav"`
+	RAM       sizeXML     `xml:"ram"`
+	Buttons   string      `xml:"buttons"`
+	PowerType string      `xml:"power-type"`
+}
+
+type screenXML struct {
+	DiagonalLength float64 `xml:"diagonal-length"`
+	PixelDensity   string  `xml:"pixel-density"`
+	Width          int     `xml:"dimensions>x-dimension"`
+	Height         int     `xml:"dimensions>y-dimension"`
+}
+
+type cameraXML struct {
+	Location string `xml:"location"`
+}
+
+type sizeXML struct {
+	Unit  string `xml:"unit,attr"`
+	Value string `xml:",chardata"`
+}
+
+// densities are the pixel densities of the density buckets, other densities are given in dpi, e.g. 420dpi.
+var densities = map[string]int{
+	"ldpi":    120,
+	"mdpi":    160,
+	"tvdpi":   213,
+	"hdpi":    240,
+	"xhdpi":   320,
+	"xxhdpi":  480,
+	"xxxhdpi": 640,
+}
+
+// Parse parses the device definitions of a devices.xml file, source identifies the file in the errors.
+func Parse(r io.Reader, source string) ([]Device, error) {
+	var doc devicesXML
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", source, err)
+	}
+
+	var devices []Device
+	for _, d := range doc.Devices {
+		device, err := d.device(source)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", source, err)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+func (d deviceXML) device(source string) (Device, error) {
+	if d.ID == "" {
+		return Device{}, fmt.Errorf("device %q has no id", d.Name)
+	}
+	density, err := parseDensity(d.Hardware.Screen.PixelDensity)
+	if err != nil {
+		return Device{}, fmt.Errorf("device %s: %w", d.ID, err)
+	}
+	ramMegabytes, err := d.Hardware.RAM.megabytes()
+	if err != nil {
+		return Device{}, fmt.Errorf("device %s: %w", d.ID, err)
+	}
+
+	sensors := strings.Fields(d.Hardware.Sensors)
+	hasSensor := func(name string) bool {
+		for _, sensor := range sensors {
+			if strings.EqualFold(sensor, name) {
+				return true
+			}
+		}
+		return false
+	}
+	hasCamera := func(location string) bool {
+		for _, camera := range d.Hardware.Cameras {
+			if camera.Location == location {
+				return true
+			}
+		}
+		return false
+	}
+
+	return Device{
+		ID:             d.ID,
+		Name:           d.Name,
+		Manufacturer:   d.Manufacturer,
+		TagID:          d.TagID,
+		Source:         source,
+		ScreenWidth:    d.Hardware.Screen.Width,
+		ScreenHeight:   d.Hardware.Screen.Height,
+		Density:        density,
+		DiagonalInches: d.Hardware.Screen.DiagonalLength,
+		RAMMegabytes:   ramMegabytes,
+
+		Keyboard:        d.Hardware.Keyboard == "qwerty",
+		DPad:            d.Hardware.Nav == "dpad",
+		TrackBall:       d.Hardware.Nav == "trackball",
+		HardwareButtons: d.Hardware.Buttons == "hard",
+
+		Accelerometer:   hasSensor("Accelerometer"),
+		Gyroscope:       hasSensor("Gyroscope"),
+		ProximitySensor: hasSensor("ProximitySensor"),
+		GPS:             hasSensor("GPS"),
+		Microphone:      d.Hardware.Mic,
+		Battery:         d.Hardware.PowerType != "plugged-in",
+		BackCamera:      hasCamera("back"),
+		FrontCamera:     hasCamera("front"),
+	}, nil
+}
+
+func parseDensity(density string) (int, error) {
+	if dpi, ok := densities[density]; ok {
+		return dpi, nil
+	}
+	dpi, err := strconv.Atoi(strings.TrimSuffix(density, "dpi"))
+	if err != nil || dpi <= 0 {
+		return 0, fmt.Errorf("invalid pixel density: %q", density)
+	}
+	return dpi, nil
+}
+
+func (s sizeXML) megabytes() (int, error) {
+	value := strings.TrimSpace(s.Value)
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid RAM size: %q", value)
+	}
+
+	switch s.Unit {
+	case "B":
+		size /= 1 << 20
+	case "KiB":
+		size /= 1 << 10
+	case "MiB":
+	case "GiB":
+		size *= 1 << 10
+	case "TiB":
+		size *= 1 << 20
+	default:
+		return 0, fmt.Errorf("invalid RAM unit: %q", s.Unit)
+	}
+	return int(size), nil
+}
@@ -0,0 +1,281 @@
+// Package download downloads large files over unreliable connections: it logs the progress periodically
+// and resumes interrupted downloads.
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+const (
+	defaultProgressInterval = 10 * time.Second
+	defaultMaxAttempts      = 5
+	defaultResumeDelay      = 5 * time.Second
+)
+
+// Downloader downloads files with a retryable HTTP client.
+type Downloader struct {
+	httpClient *retryablehttp.Client
+	logger     log.Logger
+
+	// ProgressInterval is the period of the progress log.
+	ProgressInterval time.Duration
+	// MaxAttempts limits how many times a download is started, the resumed attempts included.
+	MaxAttempts int
+	// ResumeDelay is the wait before resuming an interrupted download.
+	ResumeDelay time.Duration
+}
+
+// NewDownloader returns a Downloader with the default progress interval and resume limits.
+func NewDownloader(httpClient *retryablehttp.Client, logger log.Logger) Downloader {
+	return Downloader{
+		httpClient:       httpClient,
+		logger:           logger,
+		ProgressInterval: defaultProgressInterval,
+		MaxAttempts:      defaultMaxAttempts,
+		ResumeDelay:      defaultResumeDelay,
+	}
+}
+
+// File downloads url to pth, logging the progress periodically. When the connection drops mid-stream,
+// the download is resumed from the already downloaded bytes with an HTTP Range request, or restarted from scratch
+// if the server doesn't support ranges, up to MaxAttempts times. Failing requests are retried by the HTTP client.
+func (d Downloader) File(ctx context.Context, url, pth string) error {
+	file, err := os.OpenFile(pth, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("create file %s: %w", pth, err)
+	}
+	defer file.Close()
+
+	var offset int64
+	for attempt := 1; ; attempt++ {
+		offset, err = d.attempt(ctx, url, file, offset)
+		if err == nil {
+			return nil
+		}
+
+		var interrupted interruptedDownloadError
+		if !errors.As(err, &interrupted) {
+			return err
+		}
+		if attempt >= d.MaxAttempts || ctx.Err() != nil {
+			return fmt.Errorf("download failed after %d attempts: %w", attempt, err)
+		}
+
+		d.logger.Warnf("Download interrupted at %s: %s", FormatBytes(offset), err)
+		d.logger.Warnf("Resuming in %s (attempt %d/%d)", d.ResumeDelay, attempt+1, d.MaxAttempts)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d.ResumeDelay):
+		}
+	}
+}
+
+// attempt downloads the remainder of the file starting at offset, and returns the new offset.
+// An interruptedDownloadError is returned if the download can be continued with another attempt.
+func (d Downloader) attempt(ctx context.Context, url string, file *os.File, offset int64) (int64, error) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return offset, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			d.logger.Warnf("The server doesn't support resuming downloads, restarting from the beginning")
+		}
+		offset = 0
+	case http.StatusPartialContent:
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return offset, err
+		}
+		if start != offset {
+			return 0, interruptedDownloadError{fmt.Errorf("requested range from %d, got range from %d", offset, start)}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The previous attempt received the whole body before the connection dropped.
+		if total := contentRangeTotal(resp.Header.Get("Content-Range")); total == offset {
+			return offset, nil
+		}
+		return 0, interruptedDownloadError{fmt.Errorf("requested range from %d not satisfiable", offset)}
+	default:
+		return offset, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	if err := file.Truncate(offset); err != nil {
+		return offset, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	progress := newProgressReporter(offset, total)
+	stop := d.reportProgress(progress)
+	written, err := io.Copy(file, io.TeeReader(resp.Body, progress))
+	stop()
+
+	offset += written
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			// Failed to write the file, not the connection.
+			return offset, err
+		}
+		return offset, interruptedDownloadError{err}
+	}
+	if total >= 0 && offset != total {
+		return offset, interruptedDownloadError{io.ErrUnexpectedEOF}
+	}
+
+	d.logger.Printf("Downloaded %s in %s", FormatBytes(offset), progress.elapsed().Round(time.Second))
+	return offset, nil
+}
+
+// reportProgress logs the progress every ProgressInterval until the returned function is called.
+func (d Downloader) reportProgress(progress *progressReporter) func() {
+	ticker := time.NewTicker(d.ProgressInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				d.logger.Printf("%s", progress)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// interruptedDownloadError is a download that stopped mid-stream, e.g. because the connection dropped.
+type interruptedDownloadError struct {
+	err error
+}
+
+func (e interruptedDownloadError) Error() string {
+	return e.err.Error()
+}
+
+func (e interruptedDownloadError) Unwrap() error {
+	return e.err
+}
+
+// contentRangeStart returns the first byte position of a `bytes <start>-<end>/<total>` Content-Range header.
+func contentRangeStart(contentRange string) (int64, error) {
+	rng, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range: %s", contentRange)
+	}
+	start, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range: %s", contentRange)
+	}
+	return strconv.ParseInt(start, 10, 64)
+}
+
+// contentRangeTotal returns the total length of a Content-Range header, or -1 if it's unknown.
+func contentRangeTotal(contentRange string) int64 {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok {
+		return -1
+	}
+	value, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return value
+}
+
+// progressReporter counts the bytes of a download, the bytes of earlier attempts included.
+type progressReporter struct {
+	startTime  time.Time
+	offset     int64
+	total      int64
+	downloaded atomic.Int64
+}
+
+func newProgressReporter(offset, total int64) *progressReporter {
+	return &progressReporter{startTime: time.Now(), offset: offset, total: total}
+}
+
+func (p *progressReporter) Write(b []byte) (int, error) {
+	p.downloaded.Add(int64(len(b)))
+	return len(b), nil
+}
+
+func (p *progressReporter) elapsed() time.Duration {
+	return time.Since(p.startTime)
+}
+
+func (p *progressReporter) String() string {
+	return formatProgress(p.offset, p.downloaded.Load(), p.total, p.elapsed())
+}
+
+// formatProgress describes a download having received downloaded bytes in elapsed time,
+// on top of the offset bytes of earlier attempts. The total is -1 if unknown.
+func formatProgress(offset, downloaded, total int64, elapsed time.Duration) string {
+	current := offset + downloaded
+
+	var throughput float64
+	if elapsed > 0 {
+		throughput = float64(downloaded) / elapsed.Seconds()
+	}
+	speed := FormatBytes(int64(throughput)) + "/s"
+
+	if total <= 0 {
+		return fmt.Sprintf("Downloaded %s, %s", FormatBytes(current), speed)
+	}
+
+	percent := float64(current) / float64(total) * 100
+	eta := "unknown"
+	if throughput > 0 {
+		eta = time.Duration(float64(total-current) / throughput * float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("Downloaded %s / %s (%.0f%%), %s, ETA %s", FormatBytes(current), FormatBytes(total), percent, speed, eta)
+}
+
+// FormatBytes formats a size with binary prefixes, e.g. 1.5 MiB.
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
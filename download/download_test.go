@@ -0,0 +1,197 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHTTPClient() *retryablehttp.Client {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = 10 * time.Millisecond
+	client.HTTPClient.Timeout = 500 * time.Millisecond
+	return client
+}
+
+// droppingServer serves content, dropping the connection after the given number of bytes of the consecutive responses.
+type droppingServer struct {
+	content       []byte
+	supportsRange bool
+	dropAfter     []int
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *droppingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	request := len(s.ranges)
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.mu.Unlock()
+
+	start := 0
+	if rng := r.Header.Get("Range"); rng != "" && s.supportsRange {
+		var err error
+		start, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if start >= len(s.content) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(s.content)))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(s.content)-1, len(s.content)))
+	}
+
+	body := s.content[start:]
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if start > 0 {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if request < len(s.dropAfter) && s.dropAfter[request] < len(body) {
+		body = body[:s.dropAfter[request]]
+	}
+	_, _ = w.Write(body)
+}
+
+func (s *droppingServer) requestedRanges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.ranges...)
+}
+
+func TestDownloadFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+	tests := []struct {
+		name       string
+		server     *droppingServer
+		wantErr    string
+		wantRanges []string
+	}{
+		{
+			name:       "no drops",
+			server:     &droppingServer{supportsRange: true},
+			wantRanges: []string{""},
+		},
+		{
+			name:       "resumes after drops",
+			server:     &droppingServer{supportsRange: true, dropAfter: []int{10000, 20000}},
+			wantRanges: []string{"", "bytes=10000-", "bytes=30000-"},
+		},
+		{
+			name:       "dropped after the whole body",
+			server:     &droppingServer{supportsRange: true, dropAfter: []int{len(content) - 1, 1}},
+			wantRanges: []string{"", "bytes=65535-"},
+		},
+		{
+			name:       "restarts without range support",
+			server:     &droppingServer{dropAfter: []int{10000}},
+			wantRanges: []string{"", "bytes=10000-"},
+		},
+		{
+			name:       "gives up after max attempts",
+			server:     &droppingServer{supportsRange: true, dropAfter: []int{100, 100, 100}},
+			wantErr:    "download failed after 3 attempts: unexpected EOF",
+			wantRanges: []string{"", "bytes=100-", "bytes=200-"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.server.content = content
+			server := httptest.NewServer(tt.server)
+			defer server.Close()
+
+			downloader := NewDownloader(newTestHTTPClient(), log.NewLogger())
+			downloader.MaxAttempts = 3
+			downloader.ResumeDelay = time.Millisecond
+			downloader.ProgressInterval = time.Millisecond
+
+			pth := filepath.Join(t.TempDir(), "emulator.zip")
+			err := downloader.File(context.Background(), server.URL+"/emulator.zip", pth)
+
+			require.Equal(t, tt.wantRanges, tt.server.requestedRanges())
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			downloaded, err := os.ReadFile(pth)
+			require.NoError(t, err)
+			require.Equal(t, content, downloaded)
+		})
+	}
+}
+
+func TestDownloadFile_NotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	downloader := NewDownloader(newTestHTTPClient(), log.NewLogger())
+	err := downloader.File(context.Background(), server.URL+"/emulator.zip", filepath.Join(t.TempDir(), "emulator.zip"))
+
+	require.EqualError(t, err, "unexpected status: 404 Not Found")
+}
+
+func TestFormatProgress(t *testing.T) {
+	tests := []struct {
+		name       string
+		offset     int64
+		downloaded int64
+		total      int64
+		elapsed    time.Duration
+		want       string
+	}{
+		{
+			name:       "known size",
+			downloaded: 100 << 20,
+			total:      400 << 20,
+			elapsed:    50 * time.Second,
+			want:       "Downloaded 100.0 MiB / 400.0 MiB (25%), 2.0 MiB/s, ETA 2m30s",
+		},
+		{
+			name:       "resumed download",
+			offset:     200 << 20,
+			downloaded: 100 << 20,
+			total:      400 << 20,
+			elapsed:    10 * time.Second,
+			want:       "Downloaded 300.0 MiB / 400.0 MiB (75%), 10.0 MiB/s, ETA 10s",
+		},
+		{
+			name:    "stalled",
+			total:   400 << 20,
+			elapsed: 10 * time.Second,
+			want:    "Downloaded 0 B / 400.0 MiB (0%), 0 B/s, ETA unknown",
+		},
+		{
+			name:       "unknown size",
+			downloaded: 3 << 10,
+			total:      -1,
+			elapsed:    time.Second,
+			want:       "Downloaded 3.0 KiB, 3.0 KiB/s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, formatProgress(tt.offset, tt.downloaded, tt.total, tt.elapsed))
+		})
+	}
+}
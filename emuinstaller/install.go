@@ -1,37 +1,71 @@
 package emuinstaller
 
 import (
+	"archive/zip"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
-	v1command "github.com/bitrise-io/go-utils/command"
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/download"
+	"github.com/bitrise-steplib/steps-avd-manager/unzip"
 	"github.com/hashicorp/go-retryablehttp"
 )
 
 type EmuInstaller struct {
-	androidHome string
-	cmdFactory  command.Factory
-	logger      log.Logger
-	httpClient  *retryablehttp.Client
+	androidHome     string
+	cmdFactory      command.Factory
+	logger          log.Logger
+	httpClient      *retryablehttp.Client
+	downloadBaseURL string
+	downloader      download.Downloader
 }
 
 const backupDir = "emulator_original"
 const outputBuildIdRegex = "\\(build_id (\\d+)\\)"
 
-func NewEmuInstaller(androidHome string, cmdFactory command.Factory, logger log.Logger, httpClient *retryablehttp.Client) EmuInstaller {
-	return EmuInstaller{androidHome: androidHome, cmdFactory: cmdFactory, logger: logger, httpClient: httpClient}
+// DefaultDownloadBaseURL is the Android repository hosting the emulator archives,
+// see https://developer.android.com/studio/emulator_archive
+const DefaultDownloadBaseURL = "https://redirector.gvt1.com/edgedl/android/repository"
+
+// Option configures an EmuInstaller.
+type Option func(*EmuInstaller)
+
+// WithDownloadBaseURL downloads the emulator archives from a mirror of the Android repository,
+// e.g. an internal cache or a local server in tests.
+func WithDownloadBaseURL(baseURL string) Option {
+	return func(e *EmuInstaller) {
+		e.downloadBaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+func NewEmuInstaller(androidHome string, cmdFactory command.Factory, logger log.Logger, httpClient *retryablehttp.Client, opts ...Option) EmuInstaller {
+	e := EmuInstaller{
+		androidHome:     androidHome,
+		cmdFactory:      cmdFactory,
+		logger:          logger,
+		httpClient:      httpClient,
+		downloadBaseURL: DefaultDownloadBaseURL,
+		downloader:      download.NewDownloader(httpClient, logger),
+	}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	return e
 }
 
-func (e EmuInstaller) Install(buildNumber string) error {
+// Install downloads and installs the emulator build into ANDROID_HOME, keeping the previous emulator as a backup.
+// The download stops when ctx is done, the installed emulator is only replaced after a complete download.
+func (e EmuInstaller) Install(ctx context.Context, buildNumber string) error {
 	_, err := strconv.Atoi(buildNumber)
 	if err != nil {
 		return fmt.Errorf("the provided build number (%s) is not a number. Did you use the VERSION number instead of the BUILD number maybe?", buildNumber)
@@ -49,17 +83,37 @@ func (e EmuInstaller) Install(buildNumber string) error {
 		return nil
 	}
 
-	err = e.backupEmuDir()
+	e.logger.Println()
+	e.logger.Printf("Downloading emulator build %s...", buildNumber)
+	downloadDir, err := os.MkdirTemp("", "emulator")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(downloadDir); err != nil {
+			e.logger.Warnf("Failed to remove downloaded emulator archive: %s", err)
+		}
+	}()
+
+	zipPath := filepath.Join(downloadDir, "emulator.zip")
+	err = e.download(ctx, buildNumber, zipPath)
 	if err != nil {
 		return err
 	}
+	if err := verifyEmulatorArchive(zipPath); err != nil {
+		return fmt.Errorf("invalid emulator archive: %w", err)
+	}
 
-	e.logger.Println()
-	e.logger.Printf("Downloading emulator build %s...", buildNumber)
-	err = e.download(buildNumber)
+	err = e.backupEmuDir()
 	if err != nil {
 		return err
 	}
+
+	result, err := unzip.Extract(zipPath, e.androidHome, 0)
+	if err != nil {
+		return fmt.Errorf("unzip emulator: %w", err)
+	}
+	e.logger.Printf("Extracted %d files and %d symlinks (%s)", result.Files, result.Symlinks, download.FormatBytes(result.Bytes))
 	e.logger.Printf("Duration: %s", time.Since(startTime).Round(time.Second))
 
 	isInstalled, err := e.isVersionInstalled(buildNumber)
@@ -122,52 +176,61 @@ func (e EmuInstaller) backupEmuDir() error {
 	return nil
 }
 
-func (e EmuInstaller) download(buildNumber string) error {
-	goos := runtime.GOOS
-	var arch string
-	goarch := runtime.GOARCH
-	switch goarch {
-	case "amd64":
-		arch = "x64"
-	case "arm":
-		arch = "aarch64"
-	default:
-		return fmt.Errorf("unsupported architecture %s", goarch)
-	}
-
-	url := downloadURL(goos, arch, buildNumber)
-
-	downloadDir, err := os.MkdirTemp("", "emulator")
+func (e EmuInstaller) download(ctx context.Context, buildNumber, zipPath string) error {
+	arch, err := hostArch(runtime.GOARCH)
 	if err != nil {
-		return fmt.Errorf("create temp dir: %w", err)
+		return err
 	}
-	zipPath := filepath.Join(downloadDir, "emulator.zip")
 
-	resp, err := e.httpClient.Get(url)
-	if err != nil {
+	url := downloadURL(e.downloadBaseURL, runtime.GOOS, arch, buildNumber)
+
+	e.logger.Printf("Downloading %s", url)
+	if err := e.downloader.File(ctx, url, zipPath); err != nil {
 		return fmt.Errorf("download emulator from %s: %w", url, err)
 	}
-	defer resp.Body.Close()
 
-	file, err := os.Create(zipPath)
-	if err != nil {
-		return fmt.Errorf("create file %s: %w", zipPath, err)
-	}
-	defer file.Close()
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		return fmt.Errorf("download %s to %s: %w", url, zipPath, err)
-	}
+	return nil
+}
 
-	err = v1command.UnZIP(zipPath, e.androidHome)
-	if err != nil {
-		return fmt.Errorf("unzip emulator: %w", err)
+// hostArch returns the architecture of the emulator archives for a Go architecture.
+// Apple Silicon and Linux ARM hosts are arm64.
+func hostArch(goarch string) (string, error) {
+	switch goarch {
+	case "amd64":
+		return "x64", nil
+	case "arm", "arm64":
+		return "aarch64", nil
+	default:
+		return "", fmt.Errorf("unsupported architecture %s", goarch)
 	}
+}
 
-	return nil
+func downloadURL(baseURL, os, arch, buildNumber string) string {
+	return fmt.Sprintf("%s/emulator-%s_%s-%s.zip", baseURL, os, arch, buildNumber)
 }
 
-func downloadURL(os, arch, buildNumber string) string {
-	// https://developer.android.com/studio/emulator_archive
-	return fmt.Sprintf("https://redirector.gvt1.com/edgedl/android/repository/emulator-%s_%s-%s.zip", os, arch, buildNumber)
+// emulatorBinaryEntry is the path of the emulator binary in the emulator archives.
+const emulatorBinaryEntry = "emulator/emulator"
+
+// verifyEmulatorArchive checks that the archive is an emulator package, before anything is changed in ANDROID_HOME.
+func verifyEmulatorArchive(zipPath string) error {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if path.Clean(f.Name) != emulatorBinaryEntry {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file in the archive", emulatorBinaryEntry)
+		}
+		if f.Mode().Perm()&0111 == 0 {
+			return fmt.Errorf("%s is not executable in the archive", emulatorBinaryEntry)
+		}
+		return nil
+	}
+	return fmt.Errorf("archive doesn't contain %s", emulatorBinaryEntry)
 }
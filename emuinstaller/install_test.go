@@ -1,13 +1,25 @@
 package emuinstaller
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/bitrise-steplib/steps-avd-manager/test"
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/test"
+	"github.com/hashicorp/go-retryablehttp"
 	"github.com/stretchr/testify/require"
 )
 
@@ -154,3 +166,297 @@ func TestBackupEmuDir(t *testing.T) {
 		})
 	}
 }
+
+// fakeEmulatorScript prints the version of the given build, like `emulator -version` does.
+func fakeEmulatorScript(buildNumber string) string {
+	return "#!/bin/sh\necho 'Android emulator version 35.1.4.0 (build_id " + buildNumber + ") (CL:N/A)'\n"
+}
+
+type zipEntry struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+func newZip(t *testing.T, entries ...zipEntry) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		header.SetMode(entry.mode)
+		f, err := w.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func newTestHTTPClient() *retryablehttp.Client {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = 10 * time.Millisecond
+	client.HTTPClient.Timeout = 500 * time.Millisecond
+	return client
+}
+
+func TestDownloadURL(t *testing.T) {
+	installer := NewEmuInstaller("/fake/android/home", nil, nil, nil)
+	require.Equal(t, "https://redirector.gvt1.com/edgedl/android/repository/emulator-linux_x64-12038310.zip",
+		downloadURL(installer.downloadBaseURL, "linux", "x64", "12038310"))
+
+	installer = NewEmuInstaller("/fake/android/home", nil, nil, nil, WithDownloadBaseURL("http://127.0.0.1:8080/mirror/"))
+	require.Equal(t, "http://127.0.0.1:8080/mirror/emulator-darwin_aarch64-12038310.zip",
+		downloadURL(installer.downloadBaseURL, "darwin", "aarch64", "12038310"))
+}
+
+func TestHostArch(t *testing.T) {
+	for goarch, want := range map[string]string{"amd64": "x64", "arm": "aarch64", "arm64": "aarch64"} {
+		arch, err := hostArch(goarch)
+		require.NoError(t, err)
+		require.Equal(t, want, arch, goarch)
+	}
+
+	_, err := hostArch("386")
+	require.EqualError(t, err, "unsupported architecture 386")
+}
+
+func TestInstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake emulator is a shell script")
+	}
+
+	const buildNumber = "12345"
+	validArchive := newZip(t,
+		zipEntry{name: "emulator/", mode: os.ModeDir | 0755},
+		zipEntry{name: "emulator/emulator", content: fakeEmulatorScript(buildNumber), mode: 0755},
+		zipEntry{name: "emulator/source.properties", content: "Pkg.Revision=35.1.4\n", mode: 0644},
+	)
+
+	tests := []struct {
+		name         string
+		handler      func(requestCount int32) http.HandlerFunc
+		wantErr      string
+		wantRequests int32
+		// wantUntouched is set when the installation fails before the preinstalled emulator is backed up.
+		wantUntouched bool
+	}{
+		{
+			name: "success",
+			handler: func(int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write(validArchive)
+				}
+			},
+			wantRequests: 1,
+		},
+		{
+			name: "not found",
+			handler: func(int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					http.NotFound(w, r)
+				}
+			},
+			wantErr:       "unexpected status: 404 Not Found",
+			wantRequests:  1,
+			wantUntouched: true,
+		},
+		{
+			name: "server error is retried",
+			handler: func(requestCount int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if requestCount == 1 {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					_, _ = w.Write(validArchive)
+				}
+			},
+			wantRequests: 2,
+		},
+		{
+			name: "truncated body",
+			handler: func(int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Length", strconv.Itoa(len(validArchive)))
+					_, _ = w.Write(validArchive[:len(validArchive)/2])
+				}
+			},
+			wantErr:       "download failed after 5 attempts: unexpected EOF",
+			wantRequests:  5,
+			wantUntouched: true,
+		},
+		{
+			name: "slow server is retried",
+			handler: func(requestCount int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if requestCount == 1 {
+						select {
+						case <-time.After(5 * time.Second):
+						case <-r.Context().Done():
+						}
+						return
+					}
+					_, _ = w.Write(validArchive)
+				}
+			},
+			wantRequests: 2,
+		},
+		{
+			name: "slow server exhausts retries",
+			handler: func(int32) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					select {
+					case <-time.After(5 * time.Second):
+					case <-r.Context().Done():
+					}
+				}
+			},
+			wantErr:       "giving up after 3 attempt(s)",
+			wantRequests:  3,
+			wantUntouched: true,
+		},
+		{
+			name: "unexpected archive layout",
+			handler: func(int32) http.HandlerFunc {
+				archive := newZip(t,
+					zipEntry{name: "emulator-linux/", mode: os.ModeDir | 0755},
+					zipEntry{name: "emulator-linux/emulator", content: fakeEmulatorScript(buildNumber), mode: 0755},
+				)
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write(archive)
+				}
+			},
+			wantErr:       "invalid emulator archive: archive doesn't contain emulator/emulator",
+			wantRequests:  1,
+			wantUntouched: true,
+		},
+		{
+			name: "archive of another build",
+			handler: func(int32) http.HandlerFunc {
+				archive := newZip(t,
+					zipEntry{name: "emulator/", mode: os.ModeDir | 0755},
+					zipEntry{name: "emulator/emulator", content: fakeEmulatorScript("99999"), mode: 0755},
+				)
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write(archive)
+				}
+			},
+			wantErr:      "version mismatch after install",
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requestCount atomic.Int32
+			var requestPath atomic.Value
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestPath.Store(r.URL.Path)
+				tt.handler(requestCount.Add(1))(w, r)
+			}))
+			defer server.Close()
+
+			androidHome := t.TempDir()
+			emulatorDir := filepath.Join(androidHome, "emulator")
+			require.NoError(t, os.Mkdir(emulatorDir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(emulatorDir, "emulator"), []byte(fakeEmulatorScript("11111")), 0755))
+
+			installer := NewEmuInstaller(androidHome, command.NewFactory(env.NewRepository()), log.NewLogger(), newTestHTTPClient(),
+				WithDownloadBaseURL(server.URL+"/repository"))
+			installer.downloader.ResumeDelay = time.Millisecond
+			err := installer.Install(context.Background(), buildNumber)
+
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				installed, err := installer.isVersionInstalled(buildNumber)
+				require.NoError(t, err)
+				require.True(t, installed)
+			}
+			require.Equal(t, tt.wantRequests, requestCount.Load())
+			require.True(t, strings.HasPrefix(requestPath.Load().(string), "/repository/emulator-"+runtime.GOOS+"_"))
+			require.True(t, strings.HasSuffix(requestPath.Load().(string), "-"+buildNumber+".zip"))
+			if tt.wantUntouched {
+				require.NoDirExists(t, filepath.Join(androidHome, backupDir))
+				installed, err := installer.isVersionInstalled("11111")
+				require.NoError(t, err)
+				require.True(t, installed)
+			} else {
+				require.FileExists(t, filepath.Join(androidHome, backupDir, "emulator"))
+			}
+		})
+	}
+}
+
+func TestInstall_Canceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(newZip(t, zipEntry{name: "emulator/emulator", content: fakeEmulatorScript("22222"), mode: 0755}))
+	}))
+	defer server.Close()
+
+	androidHome := t.TempDir()
+	emulatorDir := filepath.Join(androidHome, "emulator")
+	require.NoError(t, os.Mkdir(emulatorDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(emulatorDir, "emulator"), []byte(fakeEmulatorScript("11111")), 0755))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	installer := NewEmuInstaller(androidHome, command.NewFactory(env.NewRepository()), log.NewLogger(), newTestHTTPClient(),
+		WithDownloadBaseURL(server.URL+"/repository"))
+	err := installer.Install(ctx, "22222")
+
+	require.ErrorIs(t, err, context.Canceled)
+	installed, err := installer.isVersionInstalled("11111")
+	require.NoError(t, err)
+	require.True(t, installed)
+	require.NoDirExists(t, filepath.Join(androidHome, backupDir))
+}
+
+func TestVerifyEmulatorArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []zipEntry
+		wantErr string
+	}{
+		{
+			name: "emulator package",
+			entries: []zipEntry{
+				{name: "emulator/", mode: os.ModeDir | 0755},
+				{name: "emulator/emulator", content: "binary", mode: 0755},
+			},
+		},
+		{
+			name:    "missing binary",
+			entries: []zipEntry{{name: "tools/emulator", content: "binary", mode: 0755}},
+			wantErr: "archive doesn't contain emulator/emulator",
+		},
+		{
+			name:    "binary not executable",
+			entries: []zipEntry{{name: "emulator/emulator", content: "binary", mode: 0644}},
+			wantErr: "emulator/emulator is not executable in the archive",
+		},
+		{
+			name:    "binary is a symlink",
+			entries: []zipEntry{{name: "emulator/emulator", content: "qemu/emulator", mode: os.ModeSymlink | 0777}},
+			wantErr: "emulator/emulator is not a regular file in the archive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(t.TempDir(), "emulator.zip")
+			require.NoError(t, os.WriteFile(pth, newZip(t, tt.entries...), 0644))
+
+			err := verifyEmulatorArchive(pth)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package hostcheck
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/download"
+)
+
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip"
+)
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name    string
+	Status  Status
+	Details string
+}
+
+// Report is the list of check results in execution order.
+type Report []Result
+
+// Failed returns the results that block the emulator from running.
+func (r Report) Failed() []Result {
+	var failed []Result
+	for _, result := range r {
+		if result.Status == StatusFail {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Print logs the report as a table.
+func (r Report) Print(logger log.Logger) {
+	nameWidth := len("Check")
+	for _, result := range r {
+		if len(result.Name) > nameWidth {
+			nameWidth = len(result.Name)
+		}
+	}
+
+	logger.Printf("%-*s  %-6s  %s", nameWidth, "Check", "Status", "Details")
+	for _, result := range r {
+		line := fmt.Sprintf("%-*s  %-6s  %s", nameWidth, result.Name, result.Status, result.Details)
+		switch result.Status {
+		case StatusFail:
+			logger.Errorf(line)
+		case StatusWarn:
+			logger.Warnf(line)
+		default:
+			logger.Printf(line)
+		}
+	}
+}
+
+// Config describes the resources the emulator run is going to need.
+type Config struct {
+	EmulatorPath string
+	AndroidHome  string
+	AVDHome      string
+	// ImageBytes is the disk space the system image download and install needs in the Android SDK, 0 if it's installed.
+	ImageBytes uint64
+	// AVDBytes is the disk space the AVD data needs in the AVD home.
+	AVDBytes uint64
+	// RAMMegabytes is the guest RAM size (hw.ramSize).
+	RAMMegabytes uint64
+}
+
+type Checker struct {
+	cmdFactory command.Factory
+	goos       string
+	procRoot   string
+	kvmPath    string
+	freeSpace  func(path string) (uint64, error)
+}
+
+func NewChecker(cmdFactory command.Factory) Checker {
+	return Checker{
+		cmdFactory: cmdFactory,
+		goos:       runtime.GOOS,
+		procRoot:   "/proc",
+		kvmPath:    "/dev/kvm",
+		freeSpace:  freeSpace,
+	}
+}
+
+// Run runs every check. Checks that don't apply to the host OS are reported as skipped.
+func (c Checker) Run(cfg Config) Report {
+	return Report{
+		c.checkKVM(),
+		c.checkCPUVirtualization(),
+		c.checkAccel(cfg.EmulatorPath),
+		c.checkDiskSpace("Disk space (Android SDK)", cfg.AndroidHome, cfg.ImageBytes),
+		c.checkDiskSpace("Disk space (AVD home)", cfg.AVDHome, cfg.AVDBytes),
+		c.checkRAM(cfg.RAMMegabytes),
+	}
+}
+
+func (c Checker) checkKVM() Result {
+	result := Result{Name: "KVM device"}
+	if c.goos != "linux" {
+		result.Status, result.Details = StatusSkip, "only needed on Linux"
+		return result
+	}
+
+	if _, err := os.Stat(c.kvmPath); err != nil {
+		result.Status, result.Details = StatusFail, fmt.Sprintf("%s is not available: %s", c.kvmPath, err)
+		return result
+	}
+	// The emulator opens the device read-write, so do the same.
+	kvm, err := os.OpenFile(c.kvmPath, os.O_RDWR, 0)
+	if err != nil {
+		result.Status, result.Details = StatusFail, fmt.Sprintf("no read/write permission on %s (add the user to the kvm group): %s", c.kvmPath, err)
+		return result
+	}
+	if err := kvm.Close(); err != nil {
+		result.Status, result.Details = StatusWarn, fmt.Sprintf("close %s: %s", c.kvmPath, err)
+		return result
+	}
+
+	result.Status, result.Details = StatusPass, c.kvmPath+" is accessible"
+	return result
+}
+
+func (c Checker) checkCPUVirtualization() Result {
+	result := Result{Name: "CPU virtualization"}
+	if c.goos != "linux" {
+		result.Status, result.Details = StatusSkip, "only checked on Linux"
+		return result
+	}
+
+	flags, err := cpuFlags(filepath.Join(c.procRoot, "cpuinfo"))
+	if err != nil {
+		result.Status, result.Details = StatusWarn, err.Error()
+		return result
+	}
+	for _, flag := range []string{"vmx", "svm"} {
+		if flags[flag] {
+			result.Status, result.Details = StatusPass, flag+" flag present"
+			return result
+		}
+	}
+
+	// ARM hosts and some nested virtualization setups don't expose the flags, KVM availability is what counts.
+	result.Status, result.Details = StatusWarn, "neither vmx nor svm flag found in cpuinfo"
+	return result
+}
+
+func (c Checker) checkAccel(emulatorPath string) Result {
+	result := Result{Name: "Emulator acceleration"}
+	if _, err := os.Stat(emulatorPath); err != nil {
+		result.Status, result.Details = StatusWarn, fmt.Sprintf("emulator not found at %s, skipping -accel-check", emulatorPath)
+		return result
+	}
+
+	out, err := c.cmdFactory.Create(emulatorPath, []string{"-accel-check"}, nil).RunAndReturnTrimmedCombinedOutput()
+	details := lastLine(out)
+	if err != nil {
+		result.Status, result.Details = StatusFail, fmt.Sprintf("-accel-check failed: %s", details)
+		return result
+	}
+
+	result.Status, result.Details = StatusPass, details
+	return result
+}
+
+func (c Checker) checkDiskSpace(name, path string, required uint64) Result {
+	result := Result{Name: name}
+	if path == "" {
+		result.Status, result.Details = StatusSkip, "path not set"
+		return result
+	}
+
+	free, err := c.freeSpace(existingParent(path))
+	if err != nil {
+		result.Status, result.Details = StatusWarn, err.Error()
+		return result
+	}
+
+	// The needed space is an estimate, so a shortage is only a warning.
+	result.Details = fmt.Sprintf("%s free in %s, about %s needed", download.FormatBytes(int64(free)), path, download.FormatBytes(int64(required)))
+	if free < required {
+		result.Status = StatusWarn
+	} else {
+		result.Status = StatusPass
+	}
+	return result
+}
+
+func (c Checker) checkRAM(requiredMegabytes uint64) Result {
+	result := Result{Name: "Free RAM"}
+	if c.goos != "linux" {
+		result.Status, result.Details = StatusSkip, "only checked on Linux"
+		return result
+	}
+
+	available, err := availableMemory(filepath.Join(c.procRoot, "meminfo"))
+	if err != nil {
+		result.Status, result.Details = StatusWarn, err.Error()
+		return result
+	}
+
+	required := requiredMegabytes * 1024 * 1024
+	result.Details = fmt.Sprintf("%s available, hw.ramSize is %s", download.FormatBytes(int64(available)), download.FormatBytes(int64(required)))
+	if available < required {
+		result.Status = StatusWarn
+	} else {
+		result.Status = StatusPass
+	}
+	return result
+}
+
+func cpuFlags(cpuinfoPath string) (map[string]bool, error) {
+	file, err := os.Open(cpuinfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("read cpuinfo: %w", err)
+	}
+	defer file.Close()
+
+	flags := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found || strings.TrimSpace(key) != "flags" {
+			continue
+		}
+		for _, flag := range strings.Fields(value) {
+			flags[flag] = true
+		}
+		// Every core reports the same flags.
+		break
+	}
+	return flags, scanner.Err()
+}
+
+func availableMemory(meminfoPath string) (uint64, error) {
+	file, err := os.Open(meminfoPath)
+	if err != nil {
+		return 0, fmt.Errorf("read meminfo: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// MemAvailable:   12345678 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kilobytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse MemAvailable: %w", err)
+		}
+		return kilobytes * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("read meminfo: %w", err)
+	}
+	return 0, errors.New("MemAvailable not found in meminfo")
+}
+
+func freeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("check free space of %s: %w", path, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// existingParent returns the path itself or its closest existing parent, as the AVD home might not exist yet.
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,121 @@
+package hostcheck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-avd-manager/test"
+	"github.com/stretchr/testify/require"
+)
+
+const cpuinfo = `processor	: 0
+vendor_id	: GenuineIntel
+flags		: fpu vme de pse tsc msr pae mce cx8 apic sep vmx ssse3
+processor	: 1
+flags		: fpu vme de pse tsc msr pae mce cx8 apic sep vmx ssse3
+`
+
+const meminfo = `MemTotal:       16303660 kB
+MemFree:         1209820 kB
+MemAvailable:    4194304 kB
+`
+
+func TestRun(t *testing.T) {
+	procRoot := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(procRoot, "cpuinfo"), []byte(cpuinfo), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(procRoot, "meminfo"), []byte(meminfo), 0644))
+
+	kvmPath := filepath.Join(t.TempDir(), "kvm")
+	require.NoError(t, os.WriteFile(kvmPath, nil, 0666))
+
+	emulatorPath := filepath.Join(t.TempDir(), "emulator")
+	require.NoError(t, os.WriteFile(emulatorPath, nil, 0755))
+
+	checker := Checker{
+		cmdFactory: test.FakeCommandFactory{Stdout: "accel:\n0\nKVM (version 12) is installed and usable.\naccel"},
+		goos:       "linux",
+		procRoot:   procRoot,
+		kvmPath:    kvmPath,
+		freeSpace: func(string) (uint64, error) {
+			return 10 << 30, nil
+		},
+	}
+
+	report := checker.Run(Config{
+		EmulatorPath: emulatorPath,
+		AndroidHome:  "/opt/android-sdk",
+		AVDHome:      "/home/user/.android/avd",
+		ImageBytes:   4 << 30,
+		AVDBytes:     12 << 30,
+		RAMMegabytes: 8192,
+	})
+
+	statuses := map[string]Status{}
+	for _, result := range report {
+		statuses[result.Name] = result.Status
+	}
+	require.Equal(t, map[string]Status{
+		"KVM device":               StatusPass,
+		"CPU virtualization":       StatusPass,
+		"Emulator acceleration":    StatusPass,
+		"Disk space (Android SDK)": StatusPass,
+		"Disk space (AVD home)":    StatusWarn,
+		"Free RAM":                 StatusWarn,
+	}, statuses)
+	require.Empty(t, report.Failed())
+}
+
+func TestRunBlockers(t *testing.T) {
+	procRoot := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(procRoot, "cpuinfo"), []byte("flags : fpu vme\n"), 0644))
+
+	emulatorPath := filepath.Join(t.TempDir(), "emulator")
+	require.NoError(t, os.WriteFile(emulatorPath, nil, 0755))
+
+	checker := Checker{
+		cmdFactory: test.FakeCommandFactory{ExitCode: 1},
+		goos:       "linux",
+		procRoot:   procRoot,
+		kvmPath:    filepath.Join(t.TempDir(), "kvm"),
+		freeSpace: func(string) (uint64, error) {
+			return 1 << 30, nil
+		},
+	}
+
+	report := checker.Run(Config{
+		EmulatorPath: emulatorPath,
+		AndroidHome:  "/opt/android-sdk",
+		AVDHome:      "/home/user/.android/avd",
+		ImageBytes:   4 << 30,
+		AVDBytes:     1 << 30,
+		RAMMegabytes: 2048,
+	})
+
+	var failed []string
+	for _, result := range report.Failed() {
+		failed = append(failed, result.Name)
+	}
+	require.Equal(t, []string{"KVM device", "Emulator acceleration"}, failed)
+}
+
+func TestRunOnMacOS(t *testing.T) {
+	checker := Checker{
+		cmdFactory: test.FakeCommandFactory{Stdout: "Hypervisor.Framework OS X Version 14.4\nHVF is installed and usable."},
+		goos:       "darwin",
+		freeSpace: func(string) (uint64, error) {
+			return 100 << 30, nil
+		},
+	}
+
+	report := checker.Run(Config{EmulatorPath: filepath.Join(t.TempDir(), "emulator")})
+
+	statuses := map[string]Status{}
+	for _, result := range report {
+		statuses[result.Name] = result.Status
+	}
+	require.Equal(t, StatusSkip, statuses["KVM device"])
+	require.Equal(t, StatusSkip, statuses["Free RAM"])
+	require.Equal(t, StatusWarn, statuses["Emulator acceleration"])
+	require.Empty(t, report.Failed())
+}
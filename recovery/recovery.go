@@ -0,0 +1,159 @@
+package recovery
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Failure is the classified reason of a failed boot attempt.
+type Failure string
+
+const (
+	FailureKernelFault Failure = "kernel-fault"
+	FailureGPU         Failure = "gpu-error"
+	FailureAVDLocked   Failure = "avd-locked"
+	FailureAVDCorrupt  Failure = "avd-corrupt"
+	FailureExitedEarly Failure = "exited-early"
+	FailureTimeout     Failure = "timeout"
+)
+
+// Action is what the step does before the next boot attempt.
+type Action string
+
+const (
+	ActionFail                  Action = "fail"
+	ActionRetry                 Action = "retry"
+	ActionRetryWithSoftwareGPU  Action = "retry-with-software-gpu"
+	ActionRetryAfterADBRestart  Action = "retry-after-adb-restart"
+	ActionRetryAfterLockCleanup Action = "retry-after-lock-cleanup"
+	ActionRetryAfterAVDRecreate Action = "retry-after-avd-recreate"
+)
+
+// KernelFaultIndicators are emulator log lines signalling that the guest kernel crashed.
+var KernelFaultIndicators = []string{" BUG: ", "Kernel panic"}
+
+// logSignatures maps emulator log lines to more specific failures, in order of precedence.
+var logSignatures = []struct {
+	failure    Failure
+	indicators []string
+}{
+	{FailureKernelFault, KernelFaultIndicators},
+	{FailureAVDCorrupt, []string{
+		"Unknown AVD name",
+		"No initial system image for this configuration",
+	}},
+	{FailureAVDLocked, []string{
+		"Running multiple emulators with the same AVD",
+		"Another emulator instance is running",
+		"is already in use by another emulator",
+		"the user data image is used by another emulator",
+	}},
+	{FailureGPU, []string{
+		"Could not initialize emulated framebuffer",
+		"OpenGLES emulation failed to initialize",
+		"Failed to load opengl libraries",
+		"vkCreateInstance failed",
+	}},
+}
+
+// Classify returns the most specific failure the emulator log points to, or fallback if the log has no known
+// failure signature.
+func Classify(fallback Failure, emulatorLog string) Failure {
+	for _, signature := range logSignatures {
+		for _, indicator := range signature.indicators {
+			if strings.Contains(emulatorLog, indicator) {
+				return signature.failure
+			}
+		}
+	}
+	return fallback
+}
+
+// Policy maps failures to escalating recovery actions and defines the backoff between attempts.
+type Policy struct {
+	actions        map[Failure][]Action
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// DefaultPolicy returns the policy used by the step: cheap actions first, recreating the AVD last.
+func DefaultPolicy() Policy {
+	return NewPolicy(map[Failure][]Action{
+		FailureKernelFault: {ActionRetry, ActionRetryWithSoftwareGPU, ActionRetryAfterAVDRecreate},
+		FailureGPU:         {ActionRetryWithSoftwareGPU, ActionRetryAfterAVDRecreate},
+		FailureAVDLocked:   {ActionRetryAfterLockCleanup, ActionRetryAfterAVDRecreate},
+		FailureAVDCorrupt:  {ActionRetryAfterAVDRecreate},
+		FailureExitedEarly: {ActionRetry, ActionRetryAfterLockCleanup, ActionRetryWithSoftwareGPU, ActionRetryAfterAVDRecreate},
+		FailureTimeout:     {ActionRetryAfterADBRestart, ActionRetryWithSoftwareGPU, ActionRetryAfterAVDRecreate},
+	}, 5*time.Second, time.Minute)
+}
+
+func NewPolicy(actions map[Failure][]Action, initialBackoff, maxBackoff time.Duration) Policy {
+	return Policy{actions: actions, initialBackoff: initialBackoff, maxBackoff: maxBackoff}
+}
+
+// Action returns the recovery action for the nth (1-based) occurrence of the failure.
+// Once the escalation list is exhausted, its last action is repeated.
+func (p Policy) Action(failure Failure, occurrence int) Action {
+	actions := p.actions[failure]
+	if len(actions) == 0 {
+		return ActionFail
+	}
+	if occurrence < 1 {
+		occurrence = 1
+	}
+	if occurrence > len(actions) {
+		return actions[len(actions)-1]
+	}
+	return actions[occurrence-1]
+}
+
+// Backoff returns the wait before the attempt following the given (1-based) failed attempt.
+// It doubles with every attempt, capped at the policy's maximum.
+func (p Policy) Backoff(failedAttempt int) time.Duration {
+	backoff := p.initialBackoff
+	for i := 1; i < failedAttempt && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxBackoff {
+		return p.maxBackoff
+	}
+	return backoff
+}
+
+// Record describes the recovery that followed a failed attempt.
+type Record struct {
+	Attempt int
+	Failure Failure
+	Action  Action
+	Backoff time.Duration
+}
+
+func (r Record) String() string {
+	return fmt.Sprintf("attempt %d: %s -> %s (after %s)", r.Attempt, r.Failure, r.Action, r.Backoff)
+}
+
+// History tracks the failures seen so far and the recovery actions chosen for them.
+type History struct {
+	policy      Policy
+	occurrences map[Failure]int
+	Records     []Record
+}
+
+func NewHistory(policy Policy) *History {
+	return &History{policy: policy, occurrences: map[Failure]int{}}
+}
+
+// Next records the failed attempt and returns the recovery that should run before the next attempt.
+func (h *History) Next(attempt int, failure Failure) Record {
+	h.occurrences[failure]++
+	record := Record{
+		Attempt: attempt,
+		Failure: failure,
+		Action:  h.policy.Action(failure, h.occurrences[failure]),
+		Backoff: h.policy.Backoff(attempt),
+	}
+	h.Records = append(h.Records, record)
+	return record
+}
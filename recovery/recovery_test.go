@@ -0,0 +1,101 @@
+package recovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback Failure
+		log      string
+		want     Failure
+	}{
+		{
+			name:     "no signature",
+			fallback: FailureTimeout,
+			log:      "INFO    | Boot completed in 12000 ms",
+			want:     FailureTimeout,
+		},
+		{
+			name:     "kernel panic",
+			fallback: FailureExitedEarly,
+			log:      "[    3.012] Kernel panic - not syncing: VFS: Unable to mount root fs",
+			want:     FailureKernelFault,
+		},
+		{
+			name:     "gpu",
+			fallback: FailureExitedEarly,
+			log:      "ERROR   | Could not initialize emulated framebuffer",
+			want:     FailureGPU,
+		},
+		{
+			name:     "locked avd",
+			fallback: FailureExitedEarly,
+			log:      "ERROR   | Running multiple emulators with the same AVD is an experimental feature.",
+			want:     FailureAVDLocked,
+		},
+		{
+			name:     "locked user data image",
+			fallback: FailureExitedEarly,
+			log:      "ERROR   | the user data image is used by another emulator. aborting",
+			want:     FailureAVDLocked,
+		},
+		{
+			name:     "port in use",
+			fallback: FailureExitedEarly,
+			log:      "ERROR   | Console port 5554 is already in use\nbind: Address already in use",
+			want:     FailureExitedEarly,
+		},
+		{
+			name:     "kernel fault takes precedence",
+			fallback: FailureTimeout,
+			log:      "ERROR   | Could not initialize emulated framebuffer\n[    1.000] BUG: unable to handle page fault",
+			want:     FailureKernelFault,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Classify(tt.fallback, tt.log))
+		})
+	}
+}
+
+func TestPolicyAction(t *testing.T) {
+	policy := NewPolicy(map[Failure][]Action{
+		FailureTimeout: {ActionRetryAfterADBRestart, ActionRetryAfterAVDRecreate},
+	}, time.Second, time.Minute)
+
+	require.Equal(t, ActionRetryAfterADBRestart, policy.Action(FailureTimeout, 1))
+	require.Equal(t, ActionRetryAfterAVDRecreate, policy.Action(FailureTimeout, 2))
+	require.Equal(t, ActionRetryAfterAVDRecreate, policy.Action(FailureTimeout, 3))
+	require.Equal(t, ActionFail, policy.Action(FailureGPU, 1))
+}
+
+func TestPolicyBackoff(t *testing.T) {
+	policy := NewPolicy(nil, 5*time.Second, 30*time.Second)
+
+	require.Equal(t, 5*time.Second, policy.Backoff(1))
+	require.Equal(t, 10*time.Second, policy.Backoff(2))
+	require.Equal(t, 20*time.Second, policy.Backoff(3))
+	require.Equal(t, 30*time.Second, policy.Backoff(4))
+	require.Equal(t, 30*time.Second, policy.Backoff(10))
+}
+
+func TestHistoryNext(t *testing.T) {
+	history := NewHistory(DefaultPolicy())
+
+	first := history.Next(1, FailureKernelFault)
+	second := history.Next(2, FailureTimeout)
+	third := history.Next(3, FailureKernelFault)
+
+	require.Equal(t, ActionRetry, first.Action)
+	require.Equal(t, ActionRetryAfterADBRestart, second.Action)
+	require.Equal(t, ActionRetryWithSoftwareGPU, third.Action)
+	require.Equal(t, 20*time.Second, third.Backoff)
+	require.Len(t, history.Records, 3)
+}
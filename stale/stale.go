@@ -0,0 +1,172 @@
+package stale
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-steplib/steps-avd-manager/avd"
+)
+
+// Process is a running emulator or qemu process.
+type Process struct {
+	PID     int
+	Cmdline []string
+}
+
+func (p Process) String() string {
+	return fmt.Sprintf("%d: %s", p.PID, strings.Join(p.Cmdline, " "))
+}
+
+// Report lists the leftovers of earlier emulator runs of an AVD.
+type Report struct {
+	Processes []Process
+	LockFiles []string
+}
+
+func (r Report) Empty() bool {
+	return len(r.Processes) == 0 && len(r.LockFiles) == 0
+}
+
+// Scan looks for emulator and qemu processes running the AVD with the given ID and for lock files in its
+// content directory. procRoot is the proc filesystem mount point, usually /proc.
+// Process scanning is skipped when procRoot doesn't exist (macOS).
+func Scan(procRoot, avdDir, id string) (Report, error) {
+	var report Report
+
+	processes, err := findProcesses(procRoot, id)
+	if err != nil {
+		return Report{}, err
+	}
+	report.Processes = processes
+
+	locks, err := avd.LockFiles(avdDir)
+	if err != nil {
+		return Report{}, err
+	}
+	report.LockFiles = locks
+
+	return report, nil
+}
+
+func findProcesses(procRoot, id string) ([]Process, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list processes in %s: %w", procRoot, err)
+	}
+
+	var processes []Process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() || pid == os.Getpid() {
+			continue
+		}
+
+		// Processes might exit while scanning, so unreadable entries are skipped.
+		content, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "cmdline"))
+		if err != nil || len(content) == 0 {
+			continue
+		}
+		cmdline := strings.Split(string(bytes.TrimRight(content, "\x00")), "\x00")
+
+		if isEmulatorBinary(cmdline[0]) && runsAVD(cmdline[1:], id) {
+			processes = append(processes, Process{PID: pid, Cmdline: cmdline})
+		}
+	}
+	return processes, nil
+}
+
+func isEmulatorBinary(path string) bool {
+	name := filepath.Base(path)
+	return name == "emulator" || strings.HasPrefix(name, "emulator64-") || strings.HasPrefix(name, "qemu-system-")
+}
+
+// runsAVD checks for both AVD selector forms: `@id` and `-avd id`.
+func runsAVD(args []string, id string) bool {
+	for i, arg := range args {
+		if arg == "@"+id {
+			return true
+		}
+		if arg == "-avd" && i+1 < len(args) && args[i+1] == id {
+			return true
+		}
+	}
+	return false
+}
+
+// killWaitTimeout bounds the wait for the killed processes to exit, they can be stuck in uninterruptible sleep.
+const killWaitTimeout = 5 * time.Second
+
+// Terminate sends SIGTERM to the processes and SIGKILL to those still running after the grace period,
+// then waits for them to exit, so their lock files can be safely removed.
+func Terminate(procRoot string, processes []Process, gracePeriod time.Duration) error {
+	for _, process := range processes {
+		if err := syscall.Kill(process.PID, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("terminate process %d: %w", process.PID, err)
+		}
+	}
+
+	running := waitForExit(procRoot, processes, gracePeriod)
+	if len(running) == 0 {
+		return nil
+	}
+
+	for _, process := range running {
+		if err := syscall.Kill(process.PID, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("kill process %d: %w", process.PID, err)
+		}
+	}
+
+	running = waitForExit(procRoot, running, killWaitTimeout)
+	if len(running) > 0 {
+		var pids []string
+		for _, process := range running {
+			pids = append(pids, strconv.Itoa(process.PID))
+		}
+		return fmt.Errorf("processes still running %s after SIGKILL: %s", killWaitTimeout, strings.Join(pids, ", "))
+	}
+	return nil
+}
+
+// waitForExit polls the processes until they exit or the timeout elapses, and returns the ones still running.
+func waitForExit(procRoot string, processes []Process, timeout time.Duration) []Process {
+	deadline := time.Now().Add(timeout)
+	for {
+		running := runningProcesses(procRoot, processes)
+		if len(running) == 0 || time.Now().After(deadline) {
+			return running
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func runningProcesses(procRoot string, processes []Process) []Process {
+	var running []Process
+	for _, process := range processes {
+		if isRunning(procRoot, process.PID) {
+			running = append(running, process)
+		}
+	}
+	return running
+}
+
+// isRunning treats zombie processes as exited: they don't hold any resources besides their PID.
+func isRunning(procRoot string, pid int) bool {
+	stat, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+
+	// Format: pid (comm) state ...; comm might contain spaces and parentheses.
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z" && fields[0] != "X"
+}
@@ -0,0 +1,88 @@
+package stale
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScan(t *testing.T) {
+	procRoot := t.TempDir()
+	writeProcess(t, procRoot, 101, "/opt/android-sdk/emulator/emulator", "@emulator", "-no-window")
+	writeProcess(t, procRoot, 102, "/opt/android-sdk/emulator/qemu/linux-x86_64/qemu-system-x86_64", "-avd", "emulator", "-no-window")
+	writeProcess(t, procRoot, 103, "/opt/android-sdk/emulator/qemu/linux-x86_64/qemu-system-x86_64", "-avd", "other")
+	writeProcess(t, procRoot, 104, "/usr/bin/vim", "@emulator")
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "self"), 0755))
+
+	avdDir := filepath.Join(t.TempDir(), "emulator.avd")
+	require.NoError(t, os.MkdirAll(filepath.Join(avdDir, "hardware-qemu.ini.lock"), 0755))
+
+	report, err := Scan(procRoot, avdDir, "emulator")
+	require.NoError(t, err)
+
+	var pids []int
+	for _, process := range report.Processes {
+		pids = append(pids, process.PID)
+	}
+	require.ElementsMatch(t, []int{101, 102}, pids)
+	require.Equal(t, []string{filepath.Join(avdDir, "hardware-qemu.ini.lock")}, report.LockFiles)
+}
+
+func TestScanWithoutProcFS(t *testing.T) {
+	report, err := Scan(filepath.Join(t.TempDir(), "proc"), t.TempDir(), "emulator")
+	require.NoError(t, err)
+	require.True(t, report.Empty())
+}
+
+func TestTerminate(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires /proc")
+	}
+
+	cmd := exec.Command("sleep", "60")
+	require.NoError(t, cmd.Start())
+	go func() {
+		_ = cmd.Wait()
+	}()
+
+	err := Terminate("/proc", []Process{{PID: cmd.Process.Pid}}, 5*time.Second)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return !isRunning("/proc", cmd.Process.Pid)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestTerminate_KillsIgnoringProcess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires /proc")
+	}
+
+	// The ignored SIGTERM disposition is kept across exec.
+	cmd := exec.Command("sh", "-c", `trap "" TERM; exec sleep 60`)
+	require.NoError(t, cmd.Start())
+	go func() {
+		_ = cmd.Wait()
+	}()
+	require.Eventually(t, func() bool {
+		cmdline, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(cmd.Process.Pid), "cmdline"))
+		return err == nil && strings.HasPrefix(string(cmdline), "sleep")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	err := Terminate("/proc", []Process{{PID: cmd.Process.Pid}}, 200*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, isRunning("/proc", cmd.Process.Pid))
+}
+
+func writeProcess(t *testing.T, procRoot string, pid int, cmdline ...string) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cmdline"), []byte(strings.Join(cmdline, "\x00")+"\x00"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stat"), []byte(strconv.Itoa(pid)+" (proc) S 1 1"), 0644))
+}
@@ -0,0 +1,85 @@
+package sysimg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// packageXMLTemplate is the package.xml sdkmanager writes into the directory of an installed system image.
+var packageXMLTemplate = template.Must(template.New(packageXMLName).Funcs(template.FuncMap{"escape": escapeXML}).Parse(
+	`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<ns2:repository xmlns:ns2="http://schemas.android.com/repository/android/common/02" xmlns:ns3="http://schemas.android.com/sdk/android/repo/sys-img2/03" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+{{- with .License}}
+    <license id="{{escape .ID}}" type="{{escape .Type}}">{{escape .Text}}</license>
+{{- end}}
+    <localPackage path="{{escape .Package.Path}}" obsolete="false">
+        <type-details xsi:type="ns3:sysImgDetailsType">
+            <api-level>{{escape .Package.Details.APILevel}}</api-level>
+{{- with .Package.Details.ExtensionLevel}}
+            <extension-level>{{escape .}}</extension-level>
+{{- end}}
+{{- with .Package.Details.BaseExtension}}
+            <base-extension>{{escape .}}</base-extension>
+{{- end}}
+            <tag>
+                <id>{{escape .Package.Details.Tag.ID}}</id>
+                <display>{{escape .Package.Details.Tag.Display}}</display>
+            </tag>
+{{- with .Package.Details.Vendor.ID}}
+            <vendor>
+                <id>{{escape .}}</id>
+                <display>{{escape $.Package.Details.Vendor.Display}}</display>
+            </vendor>
+{{- end}}
+            <abi>{{escape .Package.Details.ABI}}</abi>
+        </type-details>
+        <revision>
+            <major>{{.Package.Revision.Major}}</major>
+{{- with .Package.Revision.Minor}}
+            <minor>{{.}}</minor>
+{{- end}}
+{{- with .Package.Revision.Micro}}
+            <micro>{{.}}</micro>
+{{- end}}
+{{- with .Package.Revision.Preview}}
+            <preview>{{.}}</preview>
+{{- end}}
+        </revision>
+        <display-name>{{escape .Package.DisplayName}}</display-name>
+{{- with .License}}
+        <uses-license ref="{{escape .ID}}"/>
+{{- end}}
+    </localPackage>
+</ns2:repository>
+`))
+
+// localPackageXML returns the package.xml of the installed remote package, with the license it was installed under.
+func localPackageXML(repo repository, remote remotePackage) ([]byte, error) {
+	data := struct {
+		Package remotePackage
+		License *license
+	}{Package: remote}
+
+	if id := remote.UsesLicense.Ref; id != "" {
+		l, ok := repo.license(id)
+		if !ok {
+			return nil, fmt.Errorf("license %s of %s not found in the repository", id, remote.Path)
+		}
+		data.License = &l
+	}
+
+	var buf bytes.Buffer
+	if err := packageXMLTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xmlEscaper escapes text and attribute values, keeping the line breaks of the license texts.
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+func escapeXML(s string) string {
+	return xmlEscaper.Replace(s)
+}
@@ -0,0 +1,198 @@
+package sysimg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// repository is the system image repository XML (sys-img2-x.xml) of a tag. Namespaces are ignored,
+// the elements are matched by their local names, so every schema version of the file can be read.
+type repository struct {
+	Licenses []license       `xml:"license"`
+	Channels []channel       `xml:"channel"`
+	Packages []remotePackage `xml:"remotePackage"`
+}
+
+type license struct {
+	ID   string `xml:"id,attr"`
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type channel struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:",chardata"`
+}
+
+type reference struct {
+	Ref string `xml:"ref,attr"`
+}
+
+type remotePackage struct {
+	Path        string      `xml:"path,attr"`
+	Details     typeDetails `xml:"type-details"`
+	Revision    revision    `xml:"revision"`
+	DisplayName string      `xml:"display-name"`
+	UsesLicense reference   `xml:"uses-license"`
+	ChannelRef  reference   `xml:"channelRef"`
+	Archives    []archive   `xml:"archives>archive"`
+}
+
+type typeDetails struct {
+	APILevel       string    `xml:"api-level"`
+	ExtensionLevel string    `xml:"extension-level"`
+	BaseExtension  string    `xml:"base-extension"`
+	Tag            idDisplay `xml:"tag"`
+	Vendor         idDisplay `xml:"vendor"`
+	ABI            string    `xml:"abi"`
+}
+
+type idDisplay struct {
+	ID      string `xml:"id"`
+	Display string `xml:"display"`
+}
+
+type revision struct {
+	Major   int  `xml:"major"`
+	Minor   *int `xml:"minor"`
+	Micro   *int `xml:"micro"`
+	Preview *int `xml:"preview"`
+}
+
+func (r revision) String() string {
+	s := strconv.Itoa(r.Major)
+	for _, part := range []*int{r.Minor, r.Micro} {
+		if part == nil {
+			break
+		}
+		s += "." + strconv.Itoa(*part)
+	}
+	if r.Preview != nil {
+		s += " rc" + strconv.Itoa(*r.Preview)
+	}
+	return s
+}
+
+// less orders the revisions, previews come before the release of the same version.
+func (r revision) less(other revision) bool {
+	a, b := r.parts(), other.parts()
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func (r revision) parts() [4]int {
+	value := func(part *int, fallback int) int {
+		if part == nil {
+			return fallback
+		}
+		return *part
+	}
+	// A release is newer than any preview of it.
+	return [4]int{r.Major, value(r.Minor, 0), value(r.Micro, 0), value(r.Preview, 1<<31-1)}
+}
+
+type archive struct {
+	Size     int64    `xml:"complete>size"`
+	Checksum checksum `xml:"complete>checksum"`
+	URL      string   `xml:"complete>url"`
+	HostOS   string   `xml:"host-os"`
+	HostArch string   `xml:"host-arch"`
+}
+
+type checksum struct {
+	// Type is sha1 or sha-256, older schema versions don't set it and use sha1.
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+func parseRepository(content []byte) (repository, error) {
+	var repo repository
+	if err := xml.Unmarshal(content, &repo); err != nil {
+		return repository{}, err
+	}
+	return repo, nil
+}
+
+// channelLevel returns the stability of the channel referenced by the package: 0 (stable), 1 (beta), 2 (dev)
+// or 3 (canary). Packages without a channel reference are stable.
+func (r repository) channelLevel(p remotePackage) (int, error) {
+	if p.ChannelRef.Ref == "" {
+		return 0, nil
+	}
+	level, err := strconv.Atoi(strings.TrimPrefix(p.ChannelRef.Ref, "channel-"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid channel reference of %s: %s", p.Path, p.ChannelRef.Ref)
+	}
+	return level, nil
+}
+
+// find returns the newest revision of the package available in the channel or in a more stable one.
+func (r repository) find(pkgPath string, channel int) (remotePackage, error) {
+	var (
+		found    remotePackage
+		hasFound bool
+		inOthers []int
+	)
+	for _, p := range r.Packages {
+		if p.Path != pkgPath {
+			continue
+		}
+		level, err := r.channelLevel(p)
+		if err != nil {
+			return remotePackage{}, err
+		}
+		if level > channel {
+			inOthers = append(inOthers, level)
+			continue
+		}
+		if !hasFound || found.Revision.less(p.Revision) {
+			found, hasFound = p, true
+		}
+	}
+
+	if !hasFound {
+		if len(inOthers) > 0 {
+			return remotePackage{}, fmt.Errorf("package %s is not available in channel %d, only in less stable channels %v", pkgPath, channel, inOthers)
+		}
+		return remotePackage{}, fmt.Errorf("package %s not found in the repository", pkgPath)
+	}
+	return found, nil
+}
+
+func (r repository) license(id string) (license, bool) {
+	for _, l := range r.Licenses {
+		if l.ID == id {
+			return l, true
+		}
+	}
+	return license{}, false
+}
+
+// hostArchive returns the archive of the package for the host, system images usually have a single archive
+// for every host.
+func (p remotePackage) hostArchive(goos, goarch string) (archive, error) {
+	hostOS := map[string]string{"linux": "linux", "darwin": "macosx", "windows": "windows"}[goos]
+	hostArch := map[string]string{"amd64": "x64", "arm64": "aarch64", "386": "x86"}[goarch]
+
+	for _, a := range p.Archives {
+		if a.HostOS != "" && a.HostOS != hostOS {
+			continue
+		}
+		if a.HostArch != "" && a.HostArch != hostArch {
+			continue
+		}
+		return a, nil
+	}
+	return archive{}, fmt.Errorf("no archive of %s for %s/%s", p.Path, goos, goarch)
+}
+
+func (p remotePackage) currentHostArchive() (archive, error) {
+	return p.hostArchive(runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,323 @@
+// Package sysimg installs Android system images from the SDK repository without sdkmanager: it resolves
+// the package in the repository XML of the image's tag, downloads and verifies the archive, and extracts it
+// with a package.xml, so that sdkmanager, avdmanager and the emulator recognise the installed package.
+package sysimg
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-avd-manager/download"
+	"github.com/bitrise-steplib/steps-avd-manager/unzip"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// DefaultRepositoryURL is the Android SDK repository, the system image repositories are under its sys-img directory.
+const DefaultRepositoryURL = "https://dl.google.com/android/repository"
+
+// repositoryXMLName is the newest schema version of the system image repositories sdkmanager reads.
+const repositoryXMLName = "sys-img2-3.xml"
+
+const packageXMLName = "package.xml"
+
+// Package is a system image package, e.g. system-images;android-34;google_apis;x86_64.
+type Package struct {
+	Platform string
+	Tag      string
+	ABI      string
+}
+
+// ParsePackage parses a system image package path.
+func ParsePackage(pkgPath string) (Package, error) {
+	parts := strings.Split(pkgPath, ";")
+	if len(parts) != 4 || parts[0] != "system-images" || !strings.HasPrefix(parts[1], "android-") || parts[2] == "" || parts[3] == "" {
+		return Package{}, fmt.Errorf("invalid system image package: %s, expected format: system-images;android-<API level>;<tag>;<ABI>", pkgPath)
+	}
+	return Package{Platform: parts[1], Tag: parts[2], ABI: parts[3]}, nil
+}
+
+// Path returns the package path, as sdkmanager identifies the package.
+func (p Package) Path() string {
+	return strings.Join([]string{"system-images", p.Platform, p.Tag, p.ABI}, ";")
+}
+
+// Dir returns the installation directory of the package.
+func (p Package) Dir(androidHome string) string {
+	return filepath.Join(androidHome, "system-images", p.Platform, p.Tag, p.ABI)
+}
+
+// repositoryDir returns the directory of the repository listing the images of the tag. The images of the default
+// tag are in the android directory, the 16 KB page size variants are listed together with the regular images.
+func (p Package) repositoryDir() string {
+	switch p.Tag {
+	case "default":
+		return "android"
+	case "google_apis_ps16k":
+		return "google_apis"
+	case "google_apis_playstore_ps16k":
+		return "google_apis_playstore"
+	}
+	return p.Tag
+}
+
+// Installer installs system images.
+type Installer struct {
+	androidHome   string
+	logger        log.Logger
+	httpClient    *retryablehttp.Client
+	downloader    download.Downloader
+	repositoryURL string
+}
+
+// Option configures an Installer.
+type Option func(*Installer)
+
+// WithRepositoryURL installs the system images from a mirror of the Android SDK repository,
+// e.g. an internal cache or a local server in tests.
+func WithRepositoryURL(repositoryURL string) Option {
+	return func(i *Installer) {
+		i.repositoryURL = strings.TrimSuffix(repositoryURL, "/")
+	}
+}
+
+// NewInstaller returns an Installer installing the system images into androidHome from the Android SDK repository.
+func NewInstaller(androidHome string, httpClient *retryablehttp.Client, logger log.Logger, opts ...Option) Installer {
+	i := Installer{
+		androidHome:   androidHome,
+		logger:        logger,
+		httpClient:    httpClient,
+		downloader:    download.NewDownloader(httpClient, logger),
+		repositoryURL: DefaultRepositoryURL,
+	}
+	for _, opt := range opts {
+		opt(&i)
+	}
+	return i
+}
+
+// Install installs the newest revision of the package available in the channel (0: stable, 1: beta, 2: dev,
+// 3: canary) or in a more stable one, unless that revision is already installed.
+func (i Installer) Install(ctx context.Context, pkgPath string, channel int) error {
+	pkg, err := ParsePackage(pkgPath)
+	if err != nil {
+		return err
+	}
+
+	repoURL := fmt.Sprintf("%s/sys-img/%s/%s", i.repositoryURL, pkg.repositoryDir(), repositoryXMLName)
+	i.logger.Printf("Fetching %s", repoURL)
+	repo, err := i.fetchRepository(ctx, repoURL)
+	if err != nil {
+		return fmt.Errorf("fetch system image repository: %w", err)
+	}
+
+	remote, err := repo.find(pkg.Path(), channel)
+	if err != nil {
+		return err
+	}
+	i.logger.Printf("Found %s revision %s: %s", remote.Path, remote.Revision, remote.DisplayName)
+
+	dir := pkg.Dir(i.androidHome)
+	if installed, err := installedRevision(dir); err != nil {
+		i.logger.Warnf("Failed to read the installed revision of %s: %s", pkg.Path(), err)
+	} else if installed != nil && !installed.less(remote.Revision) {
+		i.logger.Donef("%s revision %s is already installed", pkg.Path(), installed)
+		return nil
+	}
+
+	archive, err := remote.currentHostArchive()
+	if err != nil {
+		return err
+	}
+	archiveURL, err := resolveURL(repoURL, archive.URL)
+	if err != nil {
+		return err
+	}
+
+	// The package is assembled next to its final location, so that it can be moved into place with a rename.
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp(filepath.Dir(dir), ".install-"+pkg.ABI+"-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			i.logger.Warnf("Failed to clean up %s: %s", workDir, err)
+		}
+	}()
+
+	zipPath := filepath.Join(workDir, "package.zip")
+	i.logger.Printf("Downloading %s (%s)", archiveURL, download.FormatBytes(archive.Size))
+	if err := i.downloader.File(ctx, archiveURL, zipPath); err != nil {
+		return fmt.Errorf("download %s: %w", archiveURL, err)
+	}
+	if err := verifyArchive(zipPath, archive); err != nil {
+		return fmt.Errorf("verify %s: %w", archiveURL, err)
+	}
+
+	packageDir := filepath.Join(workDir, "package")
+	stripComponents, err := singleRootDir(zipPath)
+	if err != nil {
+		return err
+	}
+	result, err := unzip.Extract(zipPath, packageDir, stripComponents)
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", archiveURL, err)
+	}
+	i.logger.Printf("Extracted %d files (%s)", result.Files, download.FormatBytes(result.Bytes))
+
+	packageXML, err := localPackageXML(repo, remote)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(packageDir, packageXMLName), packageXML, 0644); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("remove previous installation: %w", err)
+	}
+	if err := os.Rename(packageDir, dir); err != nil {
+		return err
+	}
+
+	i.logger.Donef("Installed %s revision %s to %s", pkg.Path(), remote.Revision, dir)
+	return nil
+}
+
+func (i Installer) fetchRepository(ctx context.Context, repoURL string) (repository, error) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, repoURL, nil)
+	if err != nil {
+		return repository{}, err
+	}
+	resp, err := i.httpClient.Do(req)
+	if err != nil {
+		return repository{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return repository{}, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return repository{}, err
+	}
+	return parseRepository(content)
+}
+
+// resolveURL resolves the archive URL, which is relative to the repository XML, unless it's absolute.
+func resolveURL(repoURL, archiveURL string) (string, error) {
+	base, err := url.Parse(repoURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(archiveURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive URL %s: %w", archiveURL, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+func verifyArchive(pth string, a archive) error {
+	info, err := os.Stat(pth)
+	if err != nil {
+		return err
+	}
+	if a.Size > 0 && info.Size() != a.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, downloaded %d bytes", a.Size, info.Size())
+	}
+
+	var h hash.Hash
+	switch a.Checksum.Type {
+	case "", "sha1":
+		h = sha1.New()
+	case "sha-256":
+		h = sha256.New()
+	default:
+		return fmt.Errorf("unsupported checksum type: %s", a.Checksum.Type)
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	expected := strings.ToLower(strings.TrimSpace(a.Checksum.Value))
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// singleRootDir returns 1 if all the entries of the archive are in a single top-level directory, like the ABI
+// directory of the system image archives: sdkmanager installs the content of that directory. It returns 0 otherwise.
+func singleRootDir(zipPath string) (int, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	root := ""
+	for _, f := range r.File {
+		name := path.Clean(f.Name)
+		first, _, nested := strings.Cut(name, "/")
+		if !nested && !f.Mode().IsDir() {
+			return 0, nil
+		}
+		if root == "" {
+			root = first
+		} else if first != root {
+			return 0, nil
+		}
+	}
+	if root == "" {
+		return 0, errors.New("empty archive")
+	}
+	return 1, nil
+}
+
+type localRepository struct {
+	Package struct {
+		Path     string   `xml:"path,attr"`
+		Revision revision `xml:"revision"`
+	} `xml:"localPackage"`
+}
+
+// installedRevision returns the revision in the package.xml of the installed package, or nil if it isn't installed.
+func installedRevision(dir string) (*revision, error) {
+	content, err := os.ReadFile(filepath.Join(dir, packageXMLName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var local localRepository
+	if err := xml.Unmarshal(content, &local); err != nil {
+		return nil, err
+	}
+	return &local.Package.Revision, nil
+}
@@ -0,0 +1,255 @@
+package sysimg
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// fixtureRepository serves testdata/sys-img2-3.xml and the system image archives it lists.
+type fixtureRepository struct {
+	server   *httptest.Server
+	archives map[string][]byte
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newFixtureRepository(t *testing.T) *fixtureRepository {
+	repo := &fixtureRepository{
+		archives: map[string][]byte{
+			"x86_64-34_r11.zip":    newImageArchive(t, "x86_64", 11),
+			"x86_64-34_r12.zip":    newImageArchive(t, "x86_64", 12),
+			"x86_64-34_r13.zip":    newImageArchive(t, "x86_64", 13),
+			"arm64-v8a-34_r12.zip": newImageArchive(t, "arm64-v8a", 12),
+		},
+	}
+
+	tmpl := template.Must(template.New("sys-img2-3.xml").Funcs(template.FuncMap{
+		"size": func(name string) int { return len(repo.archives[name]) },
+		"sha1": func(name string) string {
+			sum := sha1.Sum(repo.archives[name])
+			return hex.EncodeToString(sum[:])
+		},
+		"sha256": func(name string) string {
+			sum := sha256.Sum256(repo.archives[name])
+			return hex.EncodeToString(sum[:])
+		},
+	}).ParseFiles(filepath.Join("testdata", "sys-img2-3.xml")))
+
+	repo.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo.mu.Lock()
+		repo.requests = append(repo.requests, r.URL.Path)
+		repo.mu.Unlock()
+
+		name, ok := strings.CutPrefix(r.URL.Path, "/sys-img/google_apis/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if name == repositoryXMLName {
+			if err := tmpl.Execute(w, repo.server.URL); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		archive, ok := repo.archives[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(archive)
+	}))
+	t.Cleanup(repo.server.Close)
+
+	return repo
+}
+
+func (r *fixtureRepository) archiveRequests() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var requests []string
+	for _, request := range r.requests {
+		if strings.HasSuffix(request, ".zip") {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// newImageArchive returns a system image archive, its content is in the ABI directory.
+func newImageArchive(t *testing.T, abi string, revision int) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		abi + "/system.img":        "system image r" + strconv.Itoa(revision),
+		abi + "/source.properties": "Pkg.Revision=" + strconv.Itoa(revision) + "\nSystemImage.Abi=" + abi + "\n",
+		abi + "/data/misc":         "misc",
+	} {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func newTestInstaller(androidHome, repositoryURL string) Installer {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryMax = 1
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	return NewInstaller(androidHome, client, log.NewLogger(), WithRepositoryURL(repositoryURL))
+}
+
+func TestParsePackage(t *testing.T) {
+	pkg, err := ParsePackage("system-images;android-34;google_apis_playstore;arm64-v8a")
+	require.NoError(t, err)
+	require.Equal(t, Package{Platform: "android-34", Tag: "google_apis_playstore", ABI: "arm64-v8a"}, pkg)
+	require.Equal(t, filepath.Join("/sdk", "system-images", "android-34", "google_apis_playstore", "arm64-v8a"), pkg.Dir("/sdk"))
+
+	for _, invalid := range []string{"", "emulator", "platforms;android-34", "system-images;34;google_apis;x86_64", "system-images;android-34;;x86_64"} {
+		_, err := ParsePackage(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	repo := newFixtureRepository(t)
+	androidHome := t.TempDir()
+	installer := newTestInstaller(androidHome, repo.server.URL)
+
+	err := installer.Install(context.Background(), "system-images;android-34;google_apis;x86_64", 0)
+
+	require.NoError(t, err)
+	dir := filepath.Join(androidHome, "system-images", "android-34", "google_apis", "x86_64")
+	content, err := os.ReadFile(filepath.Join(dir, "system.img"))
+	require.NoError(t, err)
+	require.Equal(t, "system image r12", string(content))
+	require.FileExists(t, filepath.Join(dir, "data", "misc"))
+
+	packageXML, err := os.ReadFile(filepath.Join(dir, packageXMLName))
+	require.NoError(t, err)
+	golden := filepath.Join("testdata", "package.xml")
+	if *update {
+		require.NoError(t, os.WriteFile(golden, packageXML, 0644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	require.Equal(t, string(want), string(packageXML))
+
+	revision, err := installedRevision(dir)
+	require.NoError(t, err)
+	require.Equal(t, "12", revision.String())
+
+	entries, err := os.ReadDir(filepath.Dir(dir))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "the work directory is removed")
+
+	// The installed revision is the newest one, it isn't downloaded again.
+	require.NoError(t, installer.Install(context.Background(), "system-images;android-34;google_apis;x86_64", 0))
+	require.Equal(t, []string{"/sys-img/google_apis/x86_64-34_r12.zip"}, repo.archiveRequests())
+}
+
+func TestInstall_Channels(t *testing.T) {
+	repo := newFixtureRepository(t)
+	androidHome := t.TempDir()
+	installer := newTestInstaller(androidHome, repo.server.URL)
+
+	require.NoError(t, installer.Install(context.Background(), "system-images;android-34;google_apis;x86_64", 0))
+	require.NoError(t, os.WriteFile(filepath.Join(androidHome, "system-images", "android-34", "google_apis", "x86_64", "stale.img"), nil, 0644))
+
+	// The beta channel has a newer revision, with an absolute archive URL and a SHA-256 checksum.
+	require.NoError(t, installer.Install(context.Background(), "system-images;android-34;google_apis;x86_64", 1))
+
+	dir := filepath.Join(androidHome, "system-images", "android-34", "google_apis", "x86_64")
+	content, err := os.ReadFile(filepath.Join(dir, "system.img"))
+	require.NoError(t, err)
+	require.Equal(t, "system image r13", string(content))
+	require.NoFileExists(t, filepath.Join(dir, "stale.img"))
+	require.Equal(t, []string{"/sys-img/google_apis/x86_64-34_r12.zip", "/sys-img/google_apis/x86_64-34_r13.zip"}, repo.archiveRequests())
+}
+
+func TestInstall_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		pkg     string
+		channel int
+		wantErr string
+	}{
+		{
+			name:    "checksum mismatch",
+			pkg:     "system-images;android-34;google_apis;arm64-v8a",
+			wantErr: "checksum mismatch: expected 0000000000000000000000000000000000000000",
+		},
+		{
+			name:    "only in a less stable channel",
+			pkg:     "system-images;android-35;google_apis;x86_64",
+			wantErr: "package system-images;android-35;google_apis;x86_64 is not available in channel 0, only in less stable channels [3]",
+		},
+		{
+			name:    "unknown package",
+			pkg:     "system-images;android-33;google_apis;x86_64",
+			wantErr: "package system-images;android-33;google_apis;x86_64 not found in the repository",
+		},
+		{
+			name:    "unknown tag",
+			pkg:     "system-images;android-34;unknown;x86_64",
+			wantErr: "fetch system image repository: unexpected status: 404 Not Found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFixtureRepository(t)
+			androidHome := t.TempDir()
+			installer := newTestInstaller(androidHome, repo.server.URL)
+
+			err := installer.Install(context.Background(), tt.pkg, tt.channel)
+
+			require.ErrorContains(t, err, tt.wantErr)
+			pkg, err := ParsePackage(tt.pkg)
+			require.NoError(t, err)
+			require.NoDirExists(t, pkg.Dir(androidHome))
+			if entries, err := os.ReadDir(filepath.Dir(pkg.Dir(androidHome))); err == nil {
+				require.Empty(t, entries, "the work directory is removed")
+			}
+		})
+	}
+}
+
+func TestRevision(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+
+	release := revision{Major: 35, Minor: intPtr(0), Micro: intPtr(0)}
+	preview := revision{Major: 35, Minor: intPtr(0), Micro: intPtr(0), Preview: intPtr(2)}
+
+	require.Equal(t, "35.0.0", release.String())
+	require.Equal(t, "35.0.0 rc2", preview.String())
+	require.True(t, preview.less(release))
+	require.False(t, release.less(preview))
+	require.True(t, revision{Major: 12}.less(revision{Major: 13}))
+	require.False(t, revision{Major: 12}.less(revision{Major: 12, Minor: intPtr(0)}))
+}
@@ -1,8 +1,10 @@
 package test
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-utils/v2/command"
 )
@@ -20,6 +22,11 @@ func (f FakeCommandFactory) Create(name string, args []string, _ *command.Opts)
 	}
 }
 
+// CreateWithContext ignores ctx, the fake commands return immediately.
+func (f FakeCommandFactory) CreateWithContext(_ context.Context, name string, args []string, opts *command.Opts) command.Command {
+	return f.Create(name, args, opts)
+}
+
 type fakeCommand struct {
 	command  string
 	stdout   string
@@ -66,3 +73,30 @@ func (c fakeCommand) Start() error {
 func (c fakeCommand) Wait() error {
 	return nil
 }
+
+// FakeOutputExporter records the exported outputs.
+type FakeOutputExporter struct {
+	Outputs map[string]string
+}
+
+func NewFakeOutputExporter() *FakeOutputExporter {
+	return &FakeOutputExporter{Outputs: map[string]string{}}
+}
+
+func (e *FakeOutputExporter) ExportOutput(key, value string) error {
+	e.Outputs[key] = value
+	return nil
+}
+
+// FakeClock is stopped at Time, its timers use the real clock.
+type FakeClock struct {
+	Time time.Time
+}
+
+func (c FakeClock) Now() time.Time {
+	return c.Time
+}
+
+func (c FakeClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,510 @@
+// Command fakesdk impersonates the Android SDK tools used by the step (emulator, adb, sdkmanager and avdmanager),
+// so that the boot orchestration can be tested end-to-end on machines without KVM.
+//
+// The tool to impersonate is picked by the name of the executable, so the same binary is linked into a fake
+// ANDROID_HOME under each tool name. The behaviour is described by the YAML scenario file at $FAKE_SDK_SCENARIO,
+// and the shared state of the tools (running emulators, invocations) is kept in the $FAKE_SDK_STATE directory:
+//
+//	emulator:
+//	  build_id: "12038310"
+//	  attempts:
+//	    - behaviour: kernel-panic
+//	      after: 2s
+//	    - behaviour: boot
+//	      after: 5s
+//	sdkmanager:
+//	  exit_code: 1
+//	  output: "Error: Failed to find package"
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-steplib/steps-avd-manager/avd"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	scenarioEnvKey = "FAKE_SDK_SCENARIO"
+	stateDirEnvKey = "FAKE_SDK_STATE"
+
+	// invocationsFile is the file in the state directory listing the tool invocations, one per line.
+	invocationsFile = "invocations.log"
+	devicesDir      = "devices"
+	pidsDir         = "pids"
+	attemptsFile    = "emulator-attempts"
+)
+
+// Emulator behaviours
+const (
+	behaviourBoot        = "boot"
+	behaviourKernelPanic = "kernel-panic"
+	behaviourExitEarly   = "exit-early"
+	behaviourHang        = "hang"
+)
+
+type scenario struct {
+	Emulator   emulatorScenario `yaml:"emulator"`
+	ADB        adbScenario      `yaml:"adb"`
+	SDKManager toolScenario     `yaml:"sdkmanager"`
+	AVDManager toolScenario     `yaml:"avdmanager"`
+}
+
+type emulatorScenario struct {
+	Version string `yaml:"version"`
+	BuildID string `yaml:"build_id"`
+	// Attempts are the behaviours of the consecutive emulator starts, the last one is repeated.
+	Attempts []emulatorAttempt `yaml:"attempts"`
+	// Lifetime bounds how long an emulator process keeps running, so that nothing is left behind
+	// if a test doesn't clean up.
+	Lifetime time.Duration `yaml:"lifetime"`
+}
+
+type emulatorAttempt struct {
+	Behaviour string `yaml:"behaviour"`
+	// After is the time it takes to boot, to panic or to exit.
+	After    time.Duration `yaml:"after"`
+	Output   string        `yaml:"output"`
+	ExitCode int           `yaml:"exit_code"`
+}
+
+type adbScenario struct {
+	// Properties are the getprop values of the booted devices, on top of the defaults.
+	Properties map[string]string `yaml:"properties"`
+	// Shell maps adb shell commands (joined with spaces) to their result, other commands succeed without output.
+	Shell map[string]toolScenario `yaml:"shell"`
+}
+
+type toolScenario struct {
+	Output   string        `yaml:"output"`
+	ExitCode int           `yaml:"exit_code"`
+	Delay    time.Duration `yaml:"delay"`
+}
+
+var defaultProperties = map[string]string{
+	"ro.build.version.sdk":     "34",
+	"ro.build.version.release": "14",
+	"ro.product.cpu.abilist":   "x86_64,arm64-v8a",
+	"ro.build.fingerprint":     "google/sdk_gphone64_x86_64/emu64xa:14/UE1A.230829.036/10885157:userdebug/dev-keys",
+	"sys.boot_completed":       "1",
+}
+
+type fakeSDK struct {
+	scenario scenario
+	stateDir string
+}
+
+func main() {
+	tool := filepath.Base(os.Args[0])
+	args := os.Args[1:]
+
+	sdk, err := newFakeSDK()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+		os.Exit(2)
+	}
+	if err := sdk.record(tool, args); err != nil {
+		fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+		os.Exit(2)
+	}
+
+	var exitCode int
+	switch tool {
+	case "emulator":
+		exitCode = sdk.emulator(args)
+	case "adb":
+		exitCode = sdk.adb(args)
+	case "sdkmanager":
+		exitCode = sdk.sdkmanager(args)
+	case "avdmanager":
+		exitCode = sdk.avdmanager(args)
+	default:
+		fmt.Fprintf(os.Stderr, "fakesdk: unknown tool: %s\n", tool)
+		exitCode = 2
+	}
+	os.Exit(exitCode)
+}
+
+func newFakeSDK() (fakeSDK, error) {
+	stateDir := os.Getenv(stateDirEnvKey)
+	if stateDir == "" {
+		return fakeSDK{}, fmt.Errorf("%s is not set", stateDirEnvKey)
+	}
+
+	var s scenario
+	if pth := os.Getenv(scenarioEnvKey); pth != "" {
+		content, err := os.ReadFile(pth)
+		if err != nil {
+			return fakeSDK{}, fmt.Errorf("read scenario: %w", err)
+		}
+		if err := yaml.Unmarshal(content, &s); err != nil {
+			return fakeSDK{}, fmt.Errorf("parse scenario %s: %w", pth, err)
+		}
+	}
+	if s.Emulator.Version == "" {
+		s.Emulator.Version = "34.2.16.0"
+	}
+	if s.Emulator.BuildID == "" {
+		s.Emulator.BuildID = "12038310"
+	}
+	if s.Emulator.Lifetime == 0 {
+		s.Emulator.Lifetime = 5 * time.Minute
+	}
+
+	for _, dir := range []string{stateDir, filepath.Join(stateDir, devicesDir), filepath.Join(stateDir, pidsDir)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fakeSDK{}, err
+		}
+	}
+	return fakeSDK{scenario: s, stateDir: stateDir}, nil
+}
+
+// record appends the invocation to the invocations file, the tests assert on it.
+func (s fakeSDK) record(tool string, args []string) error {
+	f, err := os.OpenFile(filepath.Join(s.stateDir, invocationsFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, strings.Join(append([]string{tool}, args...), " ")); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runTool(t toolScenario) int {
+	time.Sleep(t.Delay)
+	if t.Output != "" {
+		fmt.Println(t.Output)
+	}
+	return t.ExitCode
+}
+
+// emulator
+
+func (s fakeSDK) emulator(args []string) int {
+	versionLine := fmt.Sprintf("Android emulator version %s (build_id %s) (CL:N/A)", s.scenario.Emulator.Version, s.scenario.Emulator.BuildID)
+	if len(args) > 0 && args[0] == "-version" {
+		fmt.Println(versionLine)
+		return 0
+	}
+
+	attempt, err := s.nextEmulatorAttempt()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+		return 2
+	}
+
+	serial := "emulator-5554"
+	for i, arg := range args {
+		if i+1 >= len(args) {
+			break
+		}
+		switch arg {
+		case "-port":
+			serial = "emulator-" + args[i+1]
+		case "-logcat-output":
+			if err := os.WriteFile(args[i+1], nil, 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+				return 2
+			}
+		}
+	}
+
+	pidFile := filepath.Join(s.stateDir, pidsDir, strconv.Itoa(os.Getpid()))
+	if err := os.WriteFile(pidFile, nil, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+		return 2
+	}
+
+	// Writes might fail once the step exited, the emulator keeps running regardless.
+	fmt.Printf("INFO    | %s\n", versionLine)
+	if attempt.Output != "" {
+		fmt.Println(attempt.Output)
+	}
+
+	deadline := time.After(s.scenario.Emulator.Lifetime)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	signal.Ignore(syscall.SIGPIPE)
+
+	switch attempt.Behaviour {
+	case behaviourExitEarly:
+		time.Sleep(attempt.After)
+		fmt.Println("FATAL   | emulator exited early")
+		if attempt.ExitCode == 0 {
+			return 1
+		}
+		return attempt.ExitCode
+	case behaviourKernelPanic:
+		if err := s.registerDevice(serial, time.Time{}); err != nil {
+			fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+			return 2
+		}
+		time.Sleep(attempt.After)
+		fmt.Printf("[    %.3f] Kernel panic - not syncing: VFS: Unable to mount root fs on unknown-block(0,0)\n", attempt.After.Seconds())
+	case behaviourHang:
+		if err := s.registerDevice(serial, time.Time{}); err != nil {
+			fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+			return 2
+		}
+	case behaviourBoot, "":
+		if err := s.registerDevice(serial, time.Now().Add(attempt.After)); err != nil {
+			fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+			return 2
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "fakesdk: unknown emulator behaviour: %s\n", attempt.Behaviour)
+		return 2
+	}
+
+	select {
+	case <-signals:
+	case <-deadline:
+	}
+	_ = os.Remove(filepath.Join(s.stateDir, devicesDir, serial))
+	return 0
+}
+
+// nextEmulatorAttempt counts the emulator starts and returns the behaviour of the current one.
+func (s fakeSDK) nextEmulatorAttempt() (emulatorAttempt, error) {
+	pth := filepath.Join(s.stateDir, attemptsFile)
+	count := 0
+	if content, err := os.ReadFile(pth); err == nil {
+		count, err = strconv.Atoi(strings.TrimSpace(string(content)))
+		if err != nil {
+			return emulatorAttempt{}, fmt.Errorf("parse %s: %w", pth, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return emulatorAttempt{}, err
+	}
+	if err := os.WriteFile(pth, []byte(strconv.Itoa(count+1)), 0644); err != nil {
+		return emulatorAttempt{}, err
+	}
+
+	attempts := s.scenario.Emulator.Attempts
+	if len(attempts) == 0 {
+		return emulatorAttempt{Behaviour: behaviourBoot}, nil
+	}
+	if count >= len(attempts) {
+		count = len(attempts) - 1
+	}
+	return attempts[count], nil
+}
+
+// registerDevice makes the device of this emulator process visible to adb, it comes online at bootTime.
+// A zero bootTime keeps the device offline.
+func (s fakeSDK) registerDevice(serial string, bootTime time.Time) error {
+	var bootAt int64
+	if !bootTime.IsZero() {
+		bootAt = bootTime.UnixNano()
+	}
+	content := fmt.Sprintf("%d %d", os.Getpid(), bootAt)
+	return os.WriteFile(filepath.Join(s.stateDir, devicesDir, serial), []byte(content), 0644)
+}
+
+type device struct {
+	serial string
+	online bool
+}
+
+// devices returns the devices of the running emulator processes.
+func (s fakeSDK) devices() ([]device, error) {
+	entries, err := os.ReadDir(filepath.Join(s.stateDir, devicesDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []device
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(s.stateDir, devicesDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var pid int
+		var bootAt int64
+		if _, err := fmt.Sscanf(string(content), "%d %d", &pid, &bootAt); err != nil {
+			return nil, fmt.Errorf("parse device %s: %w", entry.Name(), err)
+		}
+		// The emulator might have been killed without a chance to unregister its device.
+		if syscall.Kill(pid, 0) != nil {
+			continue
+		}
+
+		devices = append(devices, device{
+			serial: entry.Name(),
+			online: bootAt != 0 && time.Now().UnixNano() >= bootAt,
+		})
+	}
+	return devices, nil
+}
+
+// adb
+
+func (s fakeSDK) adb(args []string) int {
+	serial := ""
+	if len(args) >= 2 && args[0] == "-s" {
+		serial, args = args[1], args[2:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "adb: no command")
+		return 1
+	}
+
+	devices, err := s.devices()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+		return 2
+	}
+
+	switch args[0] {
+	case "devices":
+		fmt.Println("List of devices attached")
+		for i, d := range devices {
+			if d.online {
+				fmt.Printf("%s\tdevice product:sdk_gphone64_x86_64 model:sdk_gphone64_x86_64 device:emu64xa transport_id:%d\n", d.serial, i+1)
+			} else {
+				fmt.Printf("%s\toffline transport_id:%d\n", d.serial, i+1)
+			}
+		}
+		return 0
+	case "kill-server", "start-server", "reconnect":
+		return 0
+	}
+
+	if serial != "" && !isOnline(devices, serial) {
+		fmt.Fprintf(os.Stderr, "adb: device '%s' not found\n", serial)
+		return 1
+	}
+
+	if args[0] == "wait-for-device" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return 0
+	}
+
+	switch args[0] {
+	case "shell":
+		return s.adbShell(args[1:])
+	case "emu":
+		fmt.Println("OK")
+		return 0
+	}
+	return 0
+}
+
+func isOnline(devices []device, serial string) bool {
+	for _, d := range devices {
+		if d.serial == serial {
+			return d.online
+		}
+	}
+	return false
+}
+
+func (s fakeSDK) adbShell(args []string) int {
+	// The shell command might be passed as a single argument, like adb does it's evaluated by the device shell.
+	shellCommand := strings.Join(args, " ")
+	if result, ok := s.scenario.ADB.Shell[shellCommand]; ok {
+		return runTool(result)
+	}
+	args = strings.Fields(shellCommand)
+
+	if len(args) == 0 || args[0] != "getprop" {
+		return 0
+	}
+
+	properties := map[string]string{}
+	for key, value := range defaultProperties {
+		properties[key] = value
+	}
+	for key, value := range s.scenario.ADB.Properties {
+		properties[key] = value
+	}
+
+	if len(args) > 1 {
+		fmt.Println(properties[args[1]])
+		return 0
+	}
+
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("[%s]: [%s]\n", key, properties[key])
+	}
+	return 0
+}
+
+// sdkmanager, avdmanager
+
+func (s fakeSDK) sdkmanager(args []string) int {
+	if exitCode := runTool(s.scenario.SDKManager); exitCode != 0 {
+		return exitCode
+	}
+
+	androidHome := os.Getenv("ANDROID_HOME")
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "system-images;") {
+			continue
+		}
+		dir := filepath.Join(append([]string{androidHome}, strings.Split(arg, ";")...)...)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+			return 2
+		}
+		fmt.Printf("Installed %s\n", arg)
+	}
+	return 0
+}
+
+func (s fakeSDK) avdmanager(args []string) int {
+	if exitCode := runTool(s.scenario.AVDManager); exitCode != 0 {
+		return exitCode
+	}
+
+	name := ""
+	for i, arg := range args {
+		if (arg == "--name" || arg == "-n") && i+1 < len(args) {
+			name = args[i+1]
+		}
+	}
+	if name == "" {
+		return 0
+	}
+
+	avdHome, err := avd.HomeDir(env.NewRepository())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+		return 2
+	}
+	avdDir := avd.Dir(avdHome, name)
+	if err := os.MkdirAll(avdDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+		return 2
+	}
+	files := map[string]string{
+		filepath.Join(avdHome, name+".ini"): fmt.Sprintf("avd.ini.encoding=UTF-8\npath=%s\ntarget=android-34\n", avdDir),
+		filepath.Join(avdDir, "config.ini"): fmt.Sprintf("AvdId=%s\navd.ini.displayname=%s\n", name, name),
+	}
+	for pth, content := range files {
+		if err := os.WriteFile(pth, []byte(content), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "fakesdk: %s\n", err)
+			return 2
+		}
+	}
+	return 0
+}
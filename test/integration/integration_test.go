@@ -0,0 +1,323 @@
+// Package integration runs the step binary against a fake ANDROID_HOME, where emulator, adb, sdkmanager
+// and avdmanager are impersonated by the test/fakesdk program according to a scenario file.
+package integration
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	stepBinary    string
+	fakeSDKBinary string
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if testing.Short() {
+		fmt.Println("Skipping integration tests in short mode")
+		os.Exit(0)
+	}
+
+	binDir, err := os.MkdirTemp("", "avd-manager-integration")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	stepBinary = filepath.Join(binDir, "step")
+	fakeSDKBinary = filepath.Join(binDir, "fakesdk")
+	for pkg, output := range map[string]string{
+		"github.com/bitrise-steplib/steps-avd-manager":              stepBinary,
+		"github.com/bitrise-steplib/steps-avd-manager/test/fakesdk": fakeSDKBinary,
+	} {
+		if out, err := exec.Command("go", "build", "-o", output, pkg).CombinedOutput(); err != nil {
+			fmt.Printf("Failed to build %s: %s\n%s\n", pkg, err, out)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(binDir)
+	os.Exit(code)
+}
+
+// fakeSDK is a fake ANDROID_HOME with the tools linked to the fakesdk binary.
+type fakeSDK struct {
+	androidHome string
+	avdHome     string
+	stateDir    string
+	deployDir   string
+	scenario    string
+}
+
+func newFakeSDK(t *testing.T, scenario string) fakeSDK {
+	root := t.TempDir()
+	sdk := fakeSDK{
+		androidHome: filepath.Join(root, "android-sdk"),
+		avdHome:     filepath.Join(root, "avd"),
+		stateDir:    filepath.Join(root, "state"),
+		deployDir:   filepath.Join(root, "deploy"),
+		scenario:    filepath.Join(root, "scenario.yml"),
+	}
+
+	for _, tool := range []string{
+		filepath.Join("emulator", "emulator"),
+		filepath.Join("platform-tools", "adb"),
+		filepath.Join("cmdline-tools", "latest", "bin", "sdkmanager"),
+		filepath.Join("cmdline-tools", "latest", "bin", "avdmanager"),
+	} {
+		pth := filepath.Join(sdk.androidHome, tool)
+		require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+		require.NoError(t, os.Symlink(fakeSDKBinary, pth))
+	}
+	for _, dir := range []string{sdk.avdHome, sdk.stateDir, sdk.deployDir} {
+		require.NoError(t, os.MkdirAll(dir, 0755))
+	}
+	require.NoError(t, os.WriteFile(sdk.scenario, []byte(scenario), 0644))
+
+	// Booted emulators outlive the step, like the real ones do.
+	t.Cleanup(func() {
+		entries, err := os.ReadDir(filepath.Join(sdk.stateDir, "pids"))
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if pid, err := strconv.Atoi(entry.Name()); err == nil {
+				_ = syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+	})
+
+	return sdk
+}
+
+// invocations returns the tool invocations, one command line per entry.
+func (s fakeSDK) invocations(t *testing.T) []string {
+	content, err := os.ReadFile(filepath.Join(s.stateDir, "invocations.log"))
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func (s fakeSDK) count(t *testing.T, prefix string) int {
+	count := 0
+	for _, invocation := range s.invocations(t) {
+		if strings.HasPrefix(invocation, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// runStep runs the step binary with the default inputs of step.yml, apart from the ones speeding up the tests,
+// overridden by inputs.
+func (s fakeSDK) runStep(t *testing.T, id string, inputs map[string]string) (string, error) {
+	envs := map[string]string{
+		"ANDROID_HOME":           s.androidHome,
+		"ANDROID_SDK_ROOT":       "",
+		"ANDROID_AVD_HOME":       s.avdHome,
+		"BITRISE_DEPLOY_DIR":     s.deployDir,
+		"ENVMAN_ENVSTORE_PATH":   filepath.Join(s.stateDir, "envstore.yml"),
+		"FAKE_SDK_SCENARIO":      s.scenario,
+		"FAKE_SDK_STATE":         s.stateDir,
+		"profile":                "pixel",
+		"api_level":              "34",
+		"tag":                    "google_apis",
+		"abi":                    "x86_64",
+		"disable_animations":     "yes",
+		"emulator_id":            id,
+		"create_command_flags":   "",
+		"sdcard_size":            "2048M",
+		"avd_creator":            "avdmanager",
+		"reuse_avd":              "recreate",
+		"start_command_flags":    "-camera-back none -camera-front none",
+		"emulator_build_number":  "preinstalled",
+		"emulator_channel":       "no update",
+		"system_image_installer": "sdkmanager",
+		"headless_mode":          "yes",
+		"host_debug_tags":        "none",
+		"device_logcat_tags":     "none",
+
+		"emulator_update_timeout":       "60",
+		"system_image_install_timeout":  "60",
+		"create_avd_timeout":            "60",
+		"boot_timeout":                  "30",
+		"boot_check_interval":           "1",
+		"max_boot_attempts":             "1",
+		"step_timeout":                  "0",
+		"kill_stale_emulators":          "no",
+		"host_check":                    "no",
+		"device_settings_preset":        "none",
+		"apk_install_grant_permissions": "yes",
+		"apk_install_allow_test_only":   "yes",
+		"network_speed":                 "full",
+		"network_delay":                 "none",
+	}
+	for key, value := range inputs {
+		envs[key] = value
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, stepBinary)
+	cmd.Env = os.Environ()
+	for key, value := range envs {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.WaitDelay = 10 * time.Second
+
+	out, err := cmd.CombinedOutput()
+	t.Logf("Step output:\n%s", out)
+	return string(out), err
+}
+
+func TestStep_Boots(t *testing.T) {
+	t.Parallel()
+
+	sdk := newFakeSDK(t, `
+emulator:
+  attempts:
+    - behaviour: boot
+      after: 2s
+adb:
+  properties:
+    ro.build.version.sdk: "34"
+`)
+
+	out, err := sdk.runStep(t, "integration_boots", nil)
+
+	require.NoError(t, err)
+	require.Contains(t, out, "$BITRISE_EMULATOR_SERIAL = emulator-5554")
+	require.Contains(t, out, "$BITRISE_EMULATOR_SDK_INT = 34")
+	require.Contains(t, sdk.invocations(t), "sdkmanager --verbose --channel=0 system-images;android-34;google_apis;x86_64")
+	require.Equal(t, 1, sdk.count(t, "avdmanager --verbose create avd --force --name integration_boots"))
+	require.Equal(t, 1, sdk.count(t, "emulator @integration_boots"))
+	require.FileExists(t, filepath.Join(sdk.avdHome, "integration_boots.ini"))
+}
+
+func TestStep_ReusesCompatibleAVD(t *testing.T) {
+	t.Parallel()
+
+	sdk := newFakeSDK(t, `
+emulator:
+  attempts:
+    - behaviour: boot
+      after: 1s
+`)
+	avdDir := filepath.Join(sdk.avdHome, "integration_reuse.avd")
+	require.NoError(t, os.MkdirAll(avdDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sdk.avdHome, "integration_reuse.ini"), []byte("path="+avdDir+"\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(avdDir, "config.ini"), []byte(
+		"abi.type=x86_64\nhw.device.name=pixel\nimage.sysdir.1=system-images/android-34/google_apis/x86_64/\ntag.id=google_apis\n"), 0644))
+
+	out, err := sdk.runStep(t, "integration_reuse", map[string]string{"reuse_avd": "fail-if-exists"})
+	require.Error(t, err)
+	require.Contains(t, out, "AVD integration_reuse already exists")
+
+	out, err = sdk.runStep(t, "integration_reuse", map[string]string{"reuse_avd": "reuse-if-compatible"})
+
+	require.NoError(t, err)
+	require.Contains(t, out, "Reusing AVD integration_reuse")
+	require.Equal(t, 0, sdk.count(t, "avdmanager"))
+	require.Equal(t, 1, sdk.count(t, "emulator @integration_reuse"))
+	for _, invocation := range sdk.invocations(t) {
+		require.NotContains(t, invocation, "-wipe-data")
+	}
+}
+
+func TestStep_PreinstalledEmulatorBuild(t *testing.T) {
+	t.Parallel()
+
+	sdk := newFakeSDK(t, `
+emulator:
+  build_id: "11237101"
+`)
+
+	out, err := sdk.runStep(t, "integration_build", map[string]string{"emulator_build_number": "11237101"})
+
+	require.NoError(t, err)
+	require.Contains(t, out, "Emulator build 11237101 is already installed")
+	require.Contains(t, sdk.invocations(t), "emulator -version")
+}
+
+func TestStep_RecoversFromKernelPanic(t *testing.T) {
+	t.Parallel()
+
+	sdk := newFakeSDK(t, `
+emulator:
+  attempts:
+    - behaviour: kernel-panic
+      after: 1s
+    - behaviour: boot
+      after: 1s
+`)
+
+	out, err := sdk.runStep(t, "integration_panic", map[string]string{"max_boot_attempts": "2"})
+
+	require.NoError(t, err)
+	require.Contains(t, out, "Emulator log contains fault")
+	require.Contains(t, out, "$BITRISE_EMULATOR_SERIAL = emulator-5554")
+	require.Equal(t, 2, sdk.count(t, "emulator @integration_panic"))
+}
+
+func TestStep_EmulatorExitsEarly(t *testing.T) {
+	t.Parallel()
+
+	sdk := newFakeSDK(t, `
+emulator:
+  attempts:
+    - behaviour: exit-early
+      output: "FATAL | Running multiple emulators with the same AVD is an experimental feature."
+`)
+
+	out, err := sdk.runStep(t, "integration_exit", nil)
+
+	require.Error(t, err)
+	require.Contains(t, out, "Emulator process exited early")
+	require.Contains(t, out, "Running multiple emulators with the same AVD")
+}
+
+func TestStep_BootTimesOut(t *testing.T) {
+	t.Parallel()
+
+	sdk := newFakeSDK(t, `
+emulator:
+  attempts:
+    - behaviour: hang
+`)
+
+	out, err := sdk.runStep(t, "integration_hang", map[string]string{"boot_timeout": "3"})
+
+	require.Error(t, err)
+	require.Contains(t, out, "Failed to boot emulator device within 3 seconds.")
+	require.NotContains(t, out, "$BITRISE_EMULATOR_SERIAL")
+}
+
+func TestStep_SystemImageInstallFails(t *testing.T) {
+	t.Parallel()
+
+	sdk := newFakeSDK(t, `
+sdkmanager:
+  exit_code: 1
+  output: "Warning: Failed to find package 'system-images;android-34;google_apis;x86_64'"
+`)
+
+	out, err := sdk.runStep(t, "integration_sdkmanager", nil)
+
+	require.Error(t, err)
+	require.Contains(t, out, "Failed to find package")
+	require.Zero(t, sdk.count(t, "avdmanager"))
+	require.Zero(t, sdk.count(t, "emulator @"))
+}
@@ -0,0 +1,271 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// Response is the scripted result of a command.
+type Response struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// Duration is how long the command runs before exiting.
+	Duration time.Duration
+	// UntilKilled keeps the command running until the context it was created with is done,
+	// e.g. to simulate the emulator process which doesn't exit after the boot completes.
+	UntilKilled bool
+}
+
+// Invocation is a command run by the code under test.
+type Invocation struct {
+	Name string
+	Args []string
+	// Matched is false if no rule matched the command.
+	Matched bool
+	// Killed is set when the command was still running when its context was done.
+	Killed bool
+}
+
+// String returns the command line with the base name of the executable, the format the rule patterns match.
+func (i Invocation) String() string {
+	return strings.Join(append([]string{filepath.Base(i.Name)}, i.Args...), " ")
+}
+
+type rule struct {
+	pattern   *regexp.Regexp
+	responses []Response
+	next      int
+}
+
+// ScriptedCommandFactory returns scripted responses for the commands matching a rule, and records every invocation.
+// Commands are matched against the base name of the executable followed by the args, e.g. `adb devices -l`.
+// Commands matching no rule fail with exit code 127.
+type ScriptedCommandFactory struct {
+	mu          sync.Mutex
+	rules       []*rule
+	invocations []*Invocation
+}
+
+func NewScriptedCommandFactory() *ScriptedCommandFactory {
+	return &ScriptedCommandFactory{}
+}
+
+// On adds a rule for the commands matching the pattern (a regular expression), the first matching rule wins.
+// The responses are returned in order for the consecutive invocations, the last one is repeated.
+func (f *ScriptedCommandFactory) On(pattern string, responses ...Response) *ScriptedCommandFactory {
+	if len(responses) == 0 {
+		responses = []Response{{}}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, &rule{pattern: regexp.MustCompile(pattern), responses: responses})
+	return f
+}
+
+// Invocations returns the commands run so far, in order.
+func (f *ScriptedCommandFactory) Invocations() []Invocation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var invocations []Invocation
+	for _, invocation := range f.invocations {
+		invocations = append(invocations, *invocation)
+	}
+	return invocations
+}
+
+// Commands returns the command lines run so far, in order.
+func (f *ScriptedCommandFactory) Commands() []string {
+	var commands []string
+	for _, invocation := range f.Invocations() {
+		commands = append(commands, invocation.String())
+	}
+	return commands
+}
+
+// Unmatched returns the command lines run so far which matched no rule.
+func (f *ScriptedCommandFactory) Unmatched() []string {
+	var commands []string
+	for _, invocation := range f.Invocations() {
+		if !invocation.Matched {
+			commands = append(commands, invocation.String())
+		}
+	}
+	return commands
+}
+
+// Count returns how many of the commands run so far match the pattern.
+func (f *ScriptedCommandFactory) Count(pattern string) int {
+	re := regexp.MustCompile(pattern)
+	count := 0
+	for _, command := range f.Commands() {
+		if re.MatchString(command) {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *ScriptedCommandFactory) Create(name string, args []string, opts *command.Opts) command.Command {
+	return f.CreateWithContext(context.Background(), name, args, opts)
+}
+
+// CreateWithContext creates a command which is killed when ctx is done, if it is still running.
+func (f *ScriptedCommandFactory) CreateWithContext(ctx context.Context, name string, args []string, opts *command.Opts) command.Command {
+	return &scriptedCommand{
+		factory: f,
+		ctx:     ctx,
+		name:    name,
+		args:    args,
+		opts:    opts,
+	}
+}
+
+// run records the invocation and returns the response of the matching rule.
+func (f *ScriptedCommandFactory) run(name string, args []string) (*Invocation, Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	invocation := &Invocation{Name: name, Args: args}
+	f.invocations = append(f.invocations, invocation)
+
+	for _, r := range f.rules {
+		if !r.pattern.MatchString(invocation.String()) {
+			continue
+		}
+		invocation.Matched = true
+		response := r.responses[r.next]
+		if r.next < len(r.responses)-1 {
+			r.next++
+		}
+		return invocation, response
+	}
+	return invocation, Response{Stderr: fmt.Sprintf("unexpected command: %s", invocation), ExitCode: 127}
+}
+
+func (f *ScriptedCommandFactory) setKilled(invocation *Invocation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	invocation.Killed = true
+}
+
+type scriptedCommand struct {
+	factory *ScriptedCommandFactory
+	ctx     context.Context
+	name    string
+	args    []string
+	opts    *command.Opts
+
+	done chan struct{}
+	err  error
+}
+
+func (c *scriptedCommand) PrintableCommandArgs() string {
+	return strings.Join(append([]string{c.name}, c.args...), " ")
+}
+
+func (c *scriptedCommand) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+func (c *scriptedCommand) RunAndReturnExitCode() (int, error) {
+	err := c.Run()
+	var exitErr exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code, err
+	}
+	return 0, err
+}
+
+func (c *scriptedCommand) RunAndReturnTrimmedOutput() (string, error) {
+	invocation, response := c.factory.run(c.name, c.args)
+	err := c.simulate(invocation, response)
+	return strings.TrimSpace(response.Stdout), err
+}
+
+func (c *scriptedCommand) RunAndReturnTrimmedCombinedOutput() (string, error) {
+	invocation, response := c.factory.run(c.name, c.args)
+	err := c.simulate(invocation, response)
+	return strings.TrimSpace(response.Stdout + response.Stderr), err
+}
+
+// Start writes the scripted output to the stdout/stderr of the command options, and runs the command
+// in the background.
+func (c *scriptedCommand) Start() error {
+	if c.done != nil {
+		return errors.New("command already started")
+	}
+	invocation, response := c.factory.run(c.name, c.args)
+
+	if c.opts != nil {
+		writeOutput(c.opts.Stdout, response.Stdout)
+		writeOutput(c.opts.Stderr, response.Stderr)
+	}
+
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.err = c.simulate(invocation, response)
+	}()
+	return nil
+}
+
+func (c *scriptedCommand) Wait() error {
+	if c.done == nil {
+		return errors.New("command not started")
+	}
+	<-c.done
+	return c.err
+}
+
+// simulate blocks for the runtime of the response, and returns its exit status.
+func (c *scriptedCommand) simulate(invocation *Invocation, response Response) error {
+	var exited <-chan time.Time
+	if !response.UntilKilled {
+		exited = time.After(response.Duration)
+	}
+
+	select {
+	case <-exited:
+	case <-c.ctx.Done():
+		c.factory.setKilled(invocation)
+		return exitError{code: -1, signal: "killed"}
+	}
+
+	if response.ExitCode != 0 {
+		return exitError{code: response.ExitCode}
+	}
+	return nil
+}
+
+func writeOutput(w io.Writer, output string) {
+	if w != nil && output != "" {
+		_, _ = io.WriteString(w, output)
+	}
+}
+
+type exitError struct {
+	code   int
+	signal string
+}
+
+func (e exitError) Error() string {
+	if e.signal != "" {
+		return "signal: " + e.signal
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
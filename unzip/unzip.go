@@ -0,0 +1,189 @@
+// Package unzip extracts zip archives safely: entries and symlinks pointing outside the destination are rejected.
+package unzip
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Result describes the extracted archive.
+type Result struct {
+	Files    int
+	Symlinks int
+	Bytes    int64
+}
+
+// Extract extracts the archive into dest, preserving the permission bits and the symlinks of the entries.
+// Entries and symlink targets pointing outside dest are rejected (zip-slip).
+//
+// The first stripComponents path elements of the entries are removed, like tar's --strip-components does,
+// e.g. to extract the content of an archive's top-level directory. Directories shallower than that are skipped.
+func Extract(zipPath, dest string, stripComponents int) (Result, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return Result{}, fmt.Errorf("open archive: %w", err)
+	}
+	defer r.Close()
+
+	var (
+		result   Result
+		symlinks []symlink
+	)
+	for _, f := range r.File {
+		name, err := entryName(f.Name, stripComponents)
+		if err != nil {
+			return result, err
+		}
+		if name == "" {
+			if !f.Mode().IsDir() {
+				return result, fmt.Errorf("entry outside the stripped directories: %s", f.Name)
+			}
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(name))
+
+		switch mode := f.Mode(); {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return result, err
+			}
+		case mode&os.ModeSymlink != 0:
+			// Symlinks are created last, so that no file is written through them.
+			symlinks = append(symlinks, symlink{file: f, name: name})
+		case mode.IsRegular():
+			written, err := extractFile(f, target)
+			if err != nil {
+				return result, fmt.Errorf("extract %s: %w", f.Name, err)
+			}
+			result.Files++
+			result.Bytes += written
+		default:
+			return result, fmt.Errorf("unsupported file type of %s: %s", f.Name, mode.Type())
+		}
+	}
+
+	for _, link := range symlinks {
+		if err := extractSymlink(link, dest); err != nil {
+			return result, fmt.Errorf("extract %s: %w", link.file.Name, err)
+		}
+		result.Symlinks++
+	}
+
+	return result, nil
+}
+
+type symlink struct {
+	file *zip.File
+	name string
+}
+
+// entryName returns the cleaned, stripped path of the archive entry, rejecting the ones outside the destination.
+// An empty name is returned for the entries removed by stripping.
+func entryName(name string, stripComponents int) (string, error) {
+	if name == "" || strings.Contains(name, `\`) || path.IsAbs(name) {
+		return "", fmt.Errorf("invalid entry in archive: %s", name)
+	}
+	cleaned := path.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("entry points outside the extraction directory: %s", name)
+	}
+
+	components := strings.Split(cleaned, "/")
+	if len(components) <= stripComponents {
+		return "", nil
+	}
+	return path.Join(components[stripComponents:]...), nil
+}
+
+func extractFile(f *zip.File, target string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+
+	// The declared size bounds the copy, an entry inflating beyond it is corrupt or malicious.
+	written, err := io.Copy(out, io.LimitReader(rc, int64(f.UncompressedSize64)+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, err
+	}
+	if written != int64(f.UncompressedSize64) {
+		return written, fmt.Errorf("size mismatch: declared %d bytes, extracted %d bytes", f.UncompressedSize64, written)
+	}
+
+	// The permission bits of OpenFile are masked by the umask.
+	return written, os.Chmod(target, f.Mode().Perm())
+}
+
+func extractSymlink(link symlink, dest string) error {
+	rc, err := link.file.Open()
+	if err != nil {
+		return err
+	}
+	linkTarget, err := io.ReadAll(io.LimitReader(rc, 4096))
+	_ = rc.Close()
+	if err != nil {
+		return err
+	}
+
+	name := link.name
+	resolved := path.Join(path.Dir(name), string(linkTarget))
+	if path.IsAbs(string(linkTarget)) || resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("symlink points outside the extraction directory: %s", linkTarget)
+	}
+
+	// The textual check above only holds if the parent directories are real directories: a symlink created earlier
+	// (e.g. d -> .) would move the link (d/e -> ../outside) to another depth.
+	if err := checkParentsAreDirs(dest, name); err != nil {
+		return err
+	}
+
+	target := filepath.Join(dest, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(string(linkTarget), target)
+}
+
+// checkParentsAreDirs rejects the entry if one of its parent directories under dest is a symlink.
+func checkParentsAreDirs(dest, name string) error {
+	parent := dest
+	for _, component := range strings.Split(path.Dir(name), "/") {
+		if component == "." {
+			break
+		}
+		parent = filepath.Join(parent, component)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("entry goes through a symlink of the archive: %s", name)
+		}
+	}
+	return nil
+}
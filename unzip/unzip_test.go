@@ -0,0 +1,154 @@
+package unzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type zipEntry struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+func writeZip(t *testing.T, entries ...zipEntry) string {
+	pth := filepath.Join(t.TempDir(), "archive.zip")
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		header.SetMode(entry.mode)
+		f, err := w.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	require.NoError(t, os.WriteFile(pth, buf.Bytes(), 0644))
+	return pth
+}
+
+func TestExtract(t *testing.T) {
+	zipPath := writeZip(t,
+		zipEntry{name: "emulator/", mode: os.ModeDir | 0755},
+		zipEntry{name: "emulator/emulator", content: "#!/bin/sh\n", mode: 0755},
+		zipEntry{name: "emulator/source.properties", content: "Pkg.Revision=35.1.4\n", mode: 0644},
+		zipEntry{name: "emulator/lib64/libc++.so.1", content: "library", mode: 0644},
+		zipEntry{name: "emulator/lib64/libc++.so", content: "libc++.so.1", mode: os.ModeSymlink | 0777},
+		zipEntry{name: "emulator/qemu/linux-x86_64/lib64", content: "../../lib64", mode: os.ModeSymlink | 0777},
+	)
+	dest := t.TempDir()
+
+	result, err := Extract(zipPath, dest, 0)
+
+	require.NoError(t, err)
+	require.Equal(t, Result{Files: 3, Symlinks: 2, Bytes: int64(len("#!/bin/sh\n") + len("Pkg.Revision=35.1.4\n") + len("library"))}, result)
+
+	info, err := os.Stat(filepath.Join(dest, "emulator", "emulator"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	info, err = os.Stat(filepath.Join(dest, "emulator", "source.properties"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	link, err := os.Readlink(filepath.Join(dest, "emulator", "lib64", "libc++.so"))
+	require.NoError(t, err)
+	require.Equal(t, "libc++.so.1", link)
+	content, err := os.ReadFile(filepath.Join(dest, "emulator", "qemu", "linux-x86_64", "lib64", "libc++.so.1"))
+	require.NoError(t, err)
+	require.Equal(t, "library", string(content))
+}
+
+func TestExtract_RejectsEntriesOutsideDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   zipEntry
+		wantErr string
+	}{
+		{
+			name:    "parent directory",
+			entry:   zipEntry{name: "../evil.sh", content: "evil", mode: 0755},
+			wantErr: "entry points outside the extraction directory: ../evil.sh",
+		},
+		{
+			name:    "nested parent directory",
+			entry:   zipEntry{name: "emulator/../../evil.sh", content: "evil", mode: 0755},
+			wantErr: "entry points outside the extraction directory: emulator/../../evil.sh",
+		},
+		{
+			name:    "absolute path",
+			entry:   zipEntry{name: "/tmp/evil.sh", content: "evil", mode: 0755},
+			wantErr: "invalid entry in archive: /tmp/evil.sh",
+		},
+		{
+			name:    "backslash path",
+			entry:   zipEntry{name: `..\evil.sh`, content: "evil", mode: 0755},
+			wantErr: `invalid entry in archive: ..\evil.sh`,
+		},
+		{
+			name:    "symlink to parent directory",
+			entry:   zipEntry{name: "emulator/lib", content: "../../../usr/lib", mode: os.ModeSymlink | 0777},
+			wantErr: "extract emulator/lib: symlink points outside the extraction directory: ../../../usr/lib",
+		},
+		{
+			name:    "symlink to absolute path",
+			entry:   zipEntry{name: "emulator/lib", content: "/usr/lib", mode: os.ModeSymlink | 0777},
+			wantErr: "extract emulator/lib: symlink points outside the extraction directory: /usr/lib",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, "android-sdk")
+			require.NoError(t, os.Mkdir(dest, 0755))
+
+			_, err := Extract(writeZip(t, tt.entry), dest, 0)
+
+			require.EqualError(t, err, tt.wantErr)
+			require.NoFileExists(t, filepath.Join(root, "evil.sh"))
+		})
+	}
+}
+
+func TestExtract_RejectsSymlinkChains(t *testing.T) {
+	root := t.TempDir()
+	dest := filepath.Join(root, "android-sdk")
+	require.NoError(t, os.Mkdir(dest, 0755))
+	zipPath := writeZip(t,
+		zipEntry{name: "d", content: ".", mode: os.ModeSymlink | 0777},
+		zipEntry{name: "d/e", content: "../outside", mode: os.ModeSymlink | 0777},
+	)
+
+	_, err := Extract(zipPath, dest, 0)
+
+	require.EqualError(t, err, "extract d/e: entry goes through a symlink of the archive: d/e")
+	require.NoFileExists(t, filepath.Join(dest, "e"))
+}
+
+func TestExtract_StripComponents(t *testing.T) {
+	zipPath := writeZip(t,
+		zipEntry{name: "x86_64/", mode: os.ModeDir | 0755},
+		zipEntry{name: "x86_64/system.img", content: "system", mode: 0644},
+		zipEntry{name: "x86_64/data/", mode: os.ModeDir | 0755},
+		zipEntry{name: "x86_64/data/misc", content: "misc", mode: 0644},
+	)
+	dest := t.TempDir()
+
+	result, err := Extract(zipPath, dest, 1)
+
+	require.NoError(t, err)
+	require.Equal(t, Result{Files: 2, Bytes: int64(len("system") + len("misc"))}, result)
+	require.FileExists(t, filepath.Join(dest, "system.img"))
+	require.FileExists(t, filepath.Join(dest, "data", "misc"))
+	require.NoDirExists(t, filepath.Join(dest, "x86_64"))
+
+	_, err = Extract(writeZip(t, zipEntry{name: "README", content: "readme", mode: 0644}), t.TempDir(), 1)
+	require.EqualError(t, err, "entry outside the stripped directories: README")
+}